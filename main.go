@@ -12,6 +12,7 @@ import (
 	"variant/vcap"
 
 	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/percona/promconfig"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -26,11 +27,16 @@ type metrics struct {
 	ScrapeInterval         prometheus.Gauge
 	ManagedNetworkPolicies prometheus.Gauge
 	DetectedScrapeConfigs  prometheus.Gauge
+	ExcludedInstances      prometheus.Gauge
+	InvalidApps            prometheus.Gauge
 	TotalIncursions        prometheus.Counter
 	ErrorIncursions        prometheus.Counter
 	ConfigLoads            prometheus.Counter
 	ConfigCacheHits        prometheus.Counter
 	OutOfBoundChanges      prometheus.Counter
+	WriteErrors            prometheus.Counter
+	ReloadFailures         prometheus.Counter
+	QuarantinedRules       *prometheus.GaugeVec
 }
 
 var _ tva.Metrics = (*metrics)(nil)
@@ -39,6 +45,21 @@ func (m metrics) IncOutOfBoundChanges() {
 	m.OutOfBoundChanges.Inc()
 }
 
+func (m metrics) IncWriteErrors() {
+	m.WriteErrors.Inc()
+}
+
+func (m metrics) IncReloadFailures() {
+	m.ReloadFailures.Inc()
+}
+
+func (m metrics) SetQuarantinedRules(quarantined []tva.QuarantinedRules) {
+	m.QuarantinedRules.Reset()
+	for _, q := range quarantined {
+		m.QuarantinedRules.WithLabelValues(q.AppGUID, q.AppName).Set(1)
+	}
+}
+
 func (m metrics) SetScrapeInterval(v float64) {
 	m.ScrapeInterval.Set(v)
 }
@@ -59,6 +80,14 @@ func (m metrics) SetDetectedScrapeConfigs(v float64) {
 	m.DetectedScrapeConfigs.Set(v)
 }
 
+func (m metrics) SetExcludedInstances(v float64) {
+	m.ExcludedInstances.Set(v)
+}
+
+func (m metrics) SetInvalidApps(v float64) {
+	m.InvalidApps.Set(v)
+}
+
 func (m metrics) IncTotalIncursions() {
 	m.TotalIncursions.Inc()
 }
@@ -67,29 +96,54 @@ func (m metrics) IncErrorIncursions() {
 	m.ErrorIncursions.Inc()
 }
 
+// credentialsMatch reports whether the request carries the basic auth credentials of the viper settings given
+func credentialsMatch(r *http.Request, usernameKey, passwordKey string) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	usernameHash := sha256.Sum256([]byte(username))
+	passwordHash := sha256.Sum256([]byte(password))
+	expectedUsernameHash := sha256.Sum256([]byte(viper.GetString(usernameKey)))
+	expectedPasswordHash := sha256.Sum256([]byte(viper.GetString(passwordKey)))
+
+	usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+	passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+	return usernameMatch && passwordMatch
+}
+
 func BasicAuth(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if ok {
-			usernameHash := sha256.Sum256([]byte(username))
-			passwordHash := sha256.Sum256([]byte(password))
-			expectedUsernameHash := sha256.Sum256([]byte(viper.GetString("basic_auth_username")))
-			expectedPasswordHash := sha256.Sum256([]byte(viper.GetString("basic_auth_password")))
-
-			usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
-			passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
-
-			if usernameMatch && passwordMatch {
-				next.ServeHTTP(w, r)
-				return
-			}
+		if credentialsMatch(r, "basic_auth_username", "basic_auth_password") {
+			next.ServeHTTP(w, r)
+			return
 		}
-
 		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 	}
 }
 
+// Admin serves requests which change something only with the admin credentials, which unlike the
+// basic auth credentials of /metrics are never handed to Prometheus. Other requests go to read.
+func Admin(read, write http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read.ServeHTTP(w, r)
+			return
+		}
+		if viper.GetString("admin_username") == "" || viper.GetString("admin_password") == "" {
+			http.Error(w, "admin credentials are required to change anything", http.StatusForbidden)
+			return
+		}
+		if credentialsMatch(r, "admin_username", "admin_password") {
+			write.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
 func main() {
 	var vcapApplication vcap.Application
 
@@ -100,9 +154,32 @@ func main() {
 	viper.SetDefault("refresh", 15)
 	viper.SetDefault("tenants", "default")
 	viper.SetDefault("spaces", "")
+	viper.SetDefault("exclude_apps", "")
+	viper.SetDefault("exclude_spaces", "")
+	viper.SetDefault("exclude_orgs", "")
 	viper.SetDefault("basic_auth_username", "")
 	viper.SetDefault("basic_auth_password", "")
+	viper.SetDefault("admin_username", "")
+	viper.SetDefault("admin_password", "")
 	viper.SetDefault("reload", true)
+	viper.SetDefault("instance_states", "")
+	viper.SetDefault("tls_insecure_skip_verify", false)
+	viper.SetDefault("file_sd_dir", "")
+	viper.SetDefault("rules_dir", "")
+	viper.SetDefault("copy_labels", "")
+	viper.SetDefault("guid_labels", false)
+	viper.SetDefault("env_config", false)
+	viper.SetDefault("label_prefix", "")
+	viper.SetDefault("backup_dir", "")
+	viper.SetDefault("backup_retention", 10)
+	viper.SetDefault("backup_max_age", "")
+	viper.SetDefault("verify_reload", true)
+	viper.SetDefault("reload_timeout", "30s")
+	viper.SetDefault("annotation_prefix", "")
+	viper.SetDefault("job_naming", tva.JobNamingName)
+	viper.SetDefault("job_name_template", "")
+	viper.SetDefault("blackbox_exporter", "")
+	viper.SetDefault("blackbox_modules", "http_2xx")
 	viper.AutomaticEnv()
 
 	// Determine thanosID
@@ -124,6 +201,16 @@ func main() {
 
 	fmt.Printf("thanosID: %s\n", thanosID)
 
+	labelSources, err := tva.ParseLabelSources(viper.GetString("copy_labels"))
+	if err != nil {
+		fmt.Printf("invalid copy_labels: %v\n", err)
+		return
+	}
+	if err := tva.ValidateJobNaming(viper.GetString("job_naming"), viper.GetString("job_name_template")); err != nil {
+		fmt.Printf("invalid job_naming: %v\n", err)
+		return
+	}
+
 	internalDomainID := viper.GetString("internal_domain_id")
 	prometheusConfig := viper.GetString("prometheus_config")
 
@@ -135,8 +222,38 @@ func main() {
 		},
 		PrometheusConfig: prometheusConfig,
 		InternalDomainID: internalDomainID,
+		InternalDomains:  viper.GetString("internal_domains"),
 		ThanosID:         thanosID,
 		ThanosURL:        viper.GetString("thanos_url"),
+		InstanceStates:   viper.GetString("instance_states"),
+		CredentialsFile:  viper.GetString("credentials_file"),
+		LabelSources:     labelSources,
+		GUIDLabels:       viper.GetBool("guid_labels"),
+		EnvConfig:        viper.GetBool("env_config"),
+		JobNaming:        viper.GetString("job_naming"),
+		JobNameTemplate:  viper.GetString("job_name_template"),
+		BackupDir:        viper.GetString("backup_dir"),
+		BackupRetention: tva.BackupRetention{
+			Count:  viper.GetInt("backup_retention"),
+			MaxAge: viper.GetDuration("backup_max_age"),
+		},
+		VerifyReload:     viper.GetBool("verify_reload"),
+		ReloadTimeout:    viper.GetDuration("reload_timeout"),
+		BlackboxExporter: viper.GetString("blackbox_exporter"),
+		BlackboxModules:  viper.GetString("blackbox_modules"),
+		ScrapeLimits: tva.ScrapeLimits{
+			SampleLimit:   viper.GetUint("max_sample_limit"),
+			LabelLimit:    viper.GetUint("max_label_limit"),
+			TargetLimit:   viper.GetUint("max_target_limit"),
+			BodySizeLimit: viper.GetString("max_body_size_limit"),
+		},
+		TLSConfig: promconfig.TLSConfig{
+			CAFile:             viper.GetString("tls_ca_file"),
+			CertFile:           viper.GetString("tls_cert_file"),
+			KeyFile:            viper.GetString("tls_key_file"),
+			ServerName:         viper.GetString("tls_server_name"),
+			InsecureSkipVerify: viper.GetBool("tls_insecure_skip_verify"),
+		},
 	}
 	metrics := metrics{
 		ScrapeInterval: promauto.NewGauge(prometheus.GaugeOpts{
@@ -147,6 +264,14 @@ func main() {
 			Name: "variant_scrape_configs_detected",
 			Help: "Detected scrape configs",
 		}),
+		ExcludedInstances: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "variant_scrape_instances_excluded",
+			Help: "Instances excluded from scraping because they are not running",
+		}),
+		InvalidApps: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "variant_scrape_apps_invalid",
+			Help: "Apps with scrape settings which could not be applied",
+		}),
 		ManagedNetworkPolicies: promauto.NewGauge(prometheus.GaugeOpts{
 			Name: "variant_network_policies_managed",
 			Help: "The number of network policies being managed by variant",
@@ -171,14 +296,33 @@ func main() {
 			Name: "variant_out_of_bound_changes_total",
 			Help: "Total number of out of bound changes detected",
 		}),
+		WriteErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "variant_write_errors_total",
+			Help: "Total number of failed config, rule or target file writes",
+		}),
+		ReloadFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "variant_reload_failures_total",
+			Help: "Total number of configs rejected by Prometheus and rolled back",
+		}),
+		QuarantinedRules: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "variant_rules_quarantined",
+			Help: "Apps whose rules are invalid and left out of the config",
+		}, []string{"app_guid", "app_name"}),
 	}
 
 	timeline, err := tva.NewTimeline(config,
 		tva.WithDebug(viper.GetBool("debug")),
 		tva.WithFrequency(refresh),
 		tva.WithTenants(viper.GetString("tenants")),
+		tva.WithLabelPrefix(viper.GetString("label_prefix")),
+		tva.WithAnnotationPrefix(viper.GetString("annotation_prefix")),
 		tva.WithSpaces(viper.GetString("spaces")),
+		tva.WithExcludeApps(viper.GetString("exclude_apps")),
+		tva.WithExcludeSpaces(viper.GetString("exclude_spaces")),
+		tva.WithExcludeOrgs(viper.GetString("exclude_orgs")),
 		tva.WithReload(viper.GetBool("reload")),
+		tva.WithFileSD(viper.GetString("file_sd_dir")),
+		tva.WithRulesDir(viper.GetString("rules_dir")),
 		tva.WithMetrics(metrics),
 	)
 	if err != nil {
@@ -188,10 +332,19 @@ func main() {
 
 	done := timeline.Start()
 
+	backups := timeline.BackupsHandler() // Restoring requires the admin credentials
 	if tva.MetricsEndpointBasicAuthEnabled() {
 		http.Handle("/metrics", BasicAuth(promhttp.Handler()))
+		http.Handle("/sd/targets", BasicAuth(timeline.ServiceDiscoveryHandler()))
+		http.Handle("/explain", BasicAuth(timeline.ExplainHandler()))
+		http.Handle("/backups", Admin(BasicAuth(backups), backups))
+		http.Handle("/status", BasicAuth(timeline.StatusHandler()))
 	} else {
 		http.Handle("/metrics", promhttp.Handler())
+		http.Handle("/sd/targets", timeline.ServiceDiscoveryHandler())
+		http.Handle("/explain", timeline.ExplainHandler())
+		http.Handle("/backups", Admin(backups, backups))
+		http.Handle("/status", timeline.StatusHandler())
 	}
 
 	// Self monitoring
@@ -0,0 +1,64 @@
+package tva
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temporary file next to filename and renames it into
+// place, syncing both the file and its directory. Readers either see the old or the new
+// content, never a partially written file, also when variant crashes halfway.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return fmt.Errorf("write %s: %w", tmp.Name(), err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("chmod %s: %w", tmp.Name(), err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", tmp.Name(), err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp.Name(), err)
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("rename %s: %w", tmp.Name(), err)
+	}
+	return syncDir(dir)
+}
+
+// writeFileIfChanged atomically writes data to filename unless it already holds data
+func writeFileIfChanged(filename string, data []byte, perm os.FileMode) error {
+	if current, err := os.ReadFile(filename); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	return WriteFileAtomic(filename, data, perm)
+}
+
+// syncDir syncs a directory so renames and removals in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return nil
+}
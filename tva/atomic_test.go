@@ -0,0 +1,42 @@
+package tva_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"variant/tva"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "prometheus.yml")
+
+	if !assert.Nil(t, os.WriteFile(filename, []byte("old"), 0600)) {
+		return
+	}
+	if !assert.Nil(t, tva.WriteFileAtomic(filename, []byte("new"), 0644)) {
+		return
+	}
+	data, err := os.ReadFile(filename)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "new", string(data))
+	info, err := os.Stat(filename)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, entries, 1)
+
+	err = tva.WriteFileAtomic(filepath.Join(dir, "missing", "prometheus.yml"), []byte("new"), 0644)
+	assert.NotNil(t, err)
+}
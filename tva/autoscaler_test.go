@@ -2,9 +2,11 @@ package tva
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 	"text/template"
 
+	"code.cloudfoundry.org/cli/resources"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,3 +31,40 @@ func TestAutoscaler_FMap(t *testing.T) {
 	}
 	assert.Equal(t, "hello [guid value] with window [window value]", tpl.String())
 }
+
+func TestParseAutoscalerBounds(t *testing.T) {
+	scalerJSON := `[{"min": 2}, {"max": 10}]`
+	maxBound := "5"
+	scalers, err := ParseAutoscaler(Metadata{Annotations: map[string]*string{
+		AnnotationAutoscalerJSON: &scalerJSON,
+		AnnotationAutoscalerMax:  &maxBound,
+	}}, "guid")
+	if !assert.Nil(t, err) || !assert.Len(t, *scalers, 2) {
+		return
+	}
+	assert.Equal(t, 2, (*scalers)[0].Min)
+	assert.Equal(t, 5, (*scalers)[0].Max)
+	assert.Equal(t, 1, (*scalers)[1].Min)
+	assert.Equal(t, 10, (*scalers)[1].Max)
+}
+
+func TestCollectAutoscalers(t *testing.T) {
+	scalerJSON := `[{"min": 2, "max": 4}]`
+	timeline := &Timeline{autoScalers: map[string][]Autoscaler{
+		"gone":        {{Min: 1, Max: 3}},
+		"unreachable": {{Min: 1, Max: 2}},
+	}}
+	apps := []resources.Application{{GUID: "scaled"}, {GUID: "unreachable"}}
+	scalers := timeline.collectAutoscalers(apps, func(guid string) (Metadata, error) {
+		if guid == "unreachable" {
+			return Metadata{}, fmt.Errorf("metadata: unavailable")
+		}
+		return Metadata{Annotations: map[string]*string{AnnotationAutoscalerJSON: &scalerJSON}}, nil
+	})
+	if !assert.Len(t, scalers, 2) {
+		return
+	}
+	assert.NotContains(t, scalers, "gone")
+	assert.Equal(t, 4, scalers["scaled"][0].Max)
+	assert.Equal(t, 2, scalers["unreachable"][0].Max)
+}
@@ -0,0 +1,361 @@
+package tva
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const backupMetadataFile = "backup.json"
+
+var ErrBackupNotFound = errors.New("backup not found")
+
+// Backup is a bundle of a Prometheus config and the rule files it references
+type Backup struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	Hash      string    `json:"hash"`      // MD5 hash of the config
+	Reconcile uint64    `json:"reconcile"` // The reconcile which wrote the config, 0 when written before variant started
+	Config    string    `json:"config"`
+	RuleFiles []string  `json:"rule_files,omitempty"`
+}
+
+// BackupRetention limits the number and age of the backups kept. Zero values mean no limit.
+type BackupRetention struct {
+	Count  int
+	MaxAge time.Duration
+}
+
+// ListBackups returns the backups in dir, newest first. Incomplete bundles are skipped.
+func ListBackups(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list backups: %w", err)
+	}
+	var backups []Backup
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name(), backupMetadataFile))
+		if err != nil {
+			continue
+		}
+		var backup Backup
+		if err := json.Unmarshal(data, &backup); err != nil || backup.ID != e.Name() {
+			continue
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Created.After(backups[j].Created)
+	})
+	return backups, nil
+}
+
+// PruneBackups removes the backups in dir exceeding the retention
+func PruneBackups(dir string, retention BackupRetention, now time.Time) error {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for i, b := range backups {
+		if (retention.Count > 0 && i >= retention.Count) || (retention.MaxAge > 0 && now.Sub(b.Created) > retention.MaxAge) {
+			if err := os.RemoveAll(filepath.Join(dir, b.ID)); err != nil {
+				return fmt.Errorf("remove backup %s: %w", b.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PruneLegacyBackups removes the <config>.<unix time> backups earlier versions wrote next to the
+// config once they exceed the retention. Being older than any bundle, they only count towards the
+// number of backups kept after the bundles, of which there are given.
+func PruneLegacyBackups(configFile string, retention BackupRetention, bundles int, now time.Time) error {
+	dir, name := filepath.Split(configFile)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return fmt.Errorf("list legacy backups: %w", err)
+	}
+	type legacyBackup struct {
+		name    string
+		created time.Time
+	}
+	var legacy []legacyBackup
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), name+".")
+		if e.IsDir() || !ok {
+			continue
+		}
+		created, err := strconv.ParseInt(suffix, 10, 64)
+		if err != nil {
+			continue
+		}
+		legacy = append(legacy, legacyBackup{name: e.Name(), created: time.Unix(created, 0)})
+	}
+	sort.Slice(legacy, func(i, j int) bool {
+		return legacy[i].created.After(legacy[j].created)
+	})
+	for i, b := range legacy {
+		if (retention.Count > 0 && bundles+i >= retention.Count) || (retention.MaxAge > 0 && now.Sub(b.created) > retention.MaxAge) {
+			if err := os.Remove(filepath.Join(dir, b.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove legacy backup %s: %w", b.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Timeline) backupDir() string {
+	if t.config.BackupDir != "" {
+		return t.config.BackupDir
+	}
+	return path.Join(path.Dir(t.config.PrometheusConfig), "backups")
+}
+
+// saveBackup bundles the config together with the rule files it references
+// and prunes the backups exceeding the retention
+func (t *Timeline) saveBackup(configData []byte) (Backup, error) {
+	backup, err := t.writeBackup(configData)
+	if err != nil {
+		return backup, err
+	}
+	return backup, t.pruneBackups(backup.Created)
+}
+
+// pruneBackups removes the bundles and the backups of earlier versions exceeding the retention
+func (t *Timeline) pruneBackups(now time.Time) error {
+	if err := PruneBackups(t.backupDir(), t.config.BackupRetention, now); err != nil {
+		return err
+	}
+	backups, err := ListBackups(t.backupDir())
+	if err != nil {
+		return err
+	}
+	return PruneLegacyBackups(t.config.PrometheusConfig, t.config.BackupRetention, len(backups), now)
+}
+
+// writeBackup bundles the config together with the rule files it references
+func (t *Timeline) writeBackup(configData []byte) (Backup, error) {
+	created := time.Now()
+	backup := Backup{
+		ID:        strconv.FormatInt(created.UnixNano(), 10),
+		Created:   created.UTC(),
+		Hash:      GetMD5Hash(string(configData)),
+		Reconcile: t.written,
+		Config:    path.Base(t.config.PrometheusConfig),
+	}
+	return t.writeBundle(filepath.Join(t.backupDir(), backup.ID), backup, configData)
+}
+
+// writeBundle writes the config and the rule files it references to the bundle folder
+func (t *Timeline) writeBundle(bundle string, backup Backup, configData []byte) (Backup, error) {
+	if err := os.MkdirAll(bundle, 0755); err != nil {
+		return backup, fmt.Errorf("create backup: %w", err)
+	}
+	if err := WriteFileAtomic(filepath.Join(bundle, backup.Config), configData, 0644); err != nil {
+		return backup, err
+	}
+	var cfg PrometheusConfig
+	if err := yaml.Unmarshal(configData, &cfg); err == nil {
+		for _, ruleFile := range cfg.RuleFiles {
+			diskPath, managed := t.managedRuleFile(ruleFile)
+			if !managed { // Only the rule files next to the config or in the rules folder are backed up
+				continue
+			}
+			bundled, ok := t.bundledRuleFile(diskPath)
+			if !ok {
+				continue
+			}
+			data, err := os.ReadFile(diskPath)
+			if err != nil {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(bundle, bundled)), 0755); err != nil {
+				return backup, fmt.Errorf("create backup: %w", err)
+			}
+			if err := WriteFileAtomic(filepath.Join(bundle, bundled), data, 0644); err != nil {
+				return backup, err
+			}
+			backup.RuleFiles = append(backup.RuleFiles, ruleFile)
+		}
+	}
+	metadata, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return backup, fmt.Errorf("marshal backup: %w", err)
+	}
+	// The metadata goes last, so an interrupted backup is never listed
+	return backup, WriteFileAtomic(filepath.Join(bundle, backupMetadataFile), metadata, 0644)
+}
+
+// bundledRuleFile returns the path a rule file is kept at in a bundle: in the rules folder of
+// the bundle by its path relative to the config folder, or in its rules_dir folder when the
+// rules folder lies elsewhere. Rule files of the same name in different folders thereby do
+// not overwrite each other. Other rule files are not backed up.
+func (t *Timeline) bundledRuleFile(diskPath string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Dir(t.config.PrometheusConfig), diskPath)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Join("rules", rel), true
+	}
+	if filepath.Dir(diskPath) == filepath.Clean(t.rulesFolder()) {
+		return filepath.Join("rules_dir", filepath.Base(diskPath)), true
+	}
+	return "", false
+}
+
+// restoreBundle writes the rule files and config of a backup back in place, rule files first
+func (t *Timeline) restoreBundle(backup Backup) error {
+	bundle := filepath.Join(t.backupDir(), backup.ID)
+	configData, err := os.ReadFile(filepath.Join(bundle, backup.Config))
+	if err != nil {
+		return fmt.Errorf("read backup: %w", err)
+	}
+	for _, ruleFile := range backup.RuleFiles {
+		diskPath, _ := t.managedRuleFile(ruleFile)
+		bundled, _ := t.bundledRuleFile(diskPath)
+		data, err := os.ReadFile(filepath.Join(bundle, bundled))
+		if errors.Is(err, os.ErrNotExist) { // Bundles of earlier versions hold rule files by their name
+			data, err = os.ReadFile(filepath.Join(bundle, filepath.Base(ruleFile)))
+		}
+		if err != nil {
+			return fmt.Errorf("read backup: %w", err)
+		}
+		// Restored rule files of apps are pruned like any other
+		if filepath.Dir(diskPath) == filepath.Clean(t.rulesFolder()) && ruleFileRE.MatchString(filepath.Base(diskPath)) {
+			if err := t.trackRuleFiles(filepath.Base(diskPath)); err != nil {
+				return err
+			}
+		}
+		if err := WriteFileAtomic(diskPath, data, 0644); err != nil {
+			t.incWriteErrors()
+			return fmt.Errorf("restore rules %s: %w", ruleFile, err)
+		}
+	}
+	if err := WriteFileAtomic(t.config.PrometheusConfig, configData, 0644); err != nil {
+		t.incWriteErrors()
+		return fmt.Errorf("restore config: %w", err)
+	}
+	return nil
+}
+
+// Backups returns the backups, newest first
+func (t *Timeline) Backups() ([]Backup, error) {
+	return ListBackups(t.backupDir())
+}
+
+// Restore restores the config and rule files of a backup and pauses reconciliation,
+// so the restored config is not overwritten until Resume is called. The current
+// config is backed up first.
+func (t *Timeline) Restore(id string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	backups, err := ListBackups(t.backupDir())
+	if err != nil {
+		return err
+	}
+	var backup *Backup
+	for i := range backups {
+		if backups[i].ID == id {
+			backup = &backups[i]
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("%w: %s", ErrBackupNotFound, id)
+	}
+	if current, err := os.ReadFile(t.config.PrometheusConfig); err == nil {
+		if _, err := t.writeBackup(current); err != nil {
+			t.incWriteErrors()
+			return fmt.Errorf("save backup: %w", err)
+		}
+	}
+	if err := t.restoreBundle(*backup); err != nil {
+		return err
+	}
+	t.paused = true
+	fmt.Printf("restored backup %s, reconciliation paused\n", backup.ID)
+	// Pruning waits for the restore, as the restored backup may be the oldest one retained
+	if err := t.pruneBackups(time.Now()); err != nil {
+		fmt.Printf("error pruning backups: %v\n", err)
+	}
+	if !t.reload {
+		return nil
+	}
+	return t.reloadPrometheus()
+}
+
+// Resume resumes reconciliation after a restore
+func (t *Timeline) Resume() {
+	t.Lock()
+	defer t.Unlock()
+	t.paused = false
+}
+
+// Paused reports whether reconciliation is paused
+func (t *Timeline) Paused() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.paused
+}
+
+type BackupsResponse struct {
+	Paused  bool     `json:"paused"`
+	Backups []Backup `json:"backups"`
+}
+
+// BackupsHandler lists the backups on GET. On POST it restores the backup given by the
+// id query parameter, or resumes reconciliation when the resume query parameter is set.
+func (t *Timeline) BackupsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			backups, err := t.Backups()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(BackupsResponse{
+				Paused:  t.Paused(),
+				Backups: backups,
+			})
+		case http.MethodPost:
+			if r.URL.Query().Has("resume") {
+				t.Resume()
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				http.Error(w, "missing id parameter", http.StatusBadRequest)
+				return
+			}
+			err := t.Restore(id)
+			if errors.Is(err, ErrBackupNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
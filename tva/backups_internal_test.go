@@ -0,0 +1,55 @@
+package tva
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleRuleFilesWithSameName(t *testing.T) {
+	dir := t.TempDir()
+	rulesDir := t.TempDir()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	config := []byte("rule_files:\n- team-a/alerts.yml\n- team-b/alerts.yml\n- " + filepath.Join(rulesDir, "alerts.yml") + "\n")
+	for name, content := range map[string]string{
+		filepath.Join(dir, "team-a", "alerts.yml"): "team-a",
+		filepath.Join(dir, "team-b", "alerts.yml"): "team-b",
+		filepath.Join(rulesDir, "alerts.yml"):      "rules-dir",
+	} {
+		if !assert.Nil(t, os.MkdirAll(filepath.Dir(name), 0755)) {
+			return
+		}
+		assert.Nil(t, os.WriteFile(name, []byte(content), 0644))
+	}
+	assert.Nil(t, os.WriteFile(configFile, config, 0644))
+	timeline := &Timeline{
+		config:   Config{PrometheusConfig: configFile},
+		rulesDir: rulesDir,
+	}
+
+	backup, err := timeline.writeBackup(config)
+	if !assert.Nil(t, err) || !assert.Len(t, backup.RuleFiles, 3) {
+		return
+	}
+	for _, name := range []string{
+		filepath.Join(dir, "team-a", "alerts.yml"),
+		filepath.Join(dir, "team-b", "alerts.yml"),
+		filepath.Join(rulesDir, "alerts.yml"),
+	} {
+		assert.Nil(t, os.WriteFile(name, []byte("changed"), 0644))
+	}
+
+	if !assert.Nil(t, timeline.restoreBundle(backup)) {
+		return
+	}
+	for name, content := range map[string]string{
+		filepath.Join(dir, "team-a", "alerts.yml"): "team-a",
+		filepath.Join(dir, "team-b", "alerts.yml"): "team-b",
+		filepath.Join(rulesDir, "alerts.yml"):      "rules-dir",
+	} {
+		data, _ := os.ReadFile(name)
+		assert.Equal(t, content, string(data), name)
+	}
+}
@@ -0,0 +1,281 @@
+package tva_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeBackup(t *testing.T, dir string, backup tva.Backup) {
+	bundle := filepath.Join(dir, backup.ID)
+	if !assert.Nil(t, os.MkdirAll(bundle, 0755)) {
+		return
+	}
+	data, _ := json.Marshal(backup)
+	assert.Nil(t, os.WriteFile(filepath.Join(bundle, "backup.json"), data, 0644))
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3", "4"} {
+		writeBackup(t, dir, tva.Backup{ID: id, Created: now.Add(-time.Duration(4-i) * time.Hour)})
+	}
+	// Incomplete bundles are not listed
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "5"), 0755))
+
+	backups, err := tva.ListBackups(dir)
+	if !assert.Nil(t, err) || !assert.Len(t, backups, 4) {
+		return
+	}
+	assert.Equal(t, "4", backups[0].ID)
+
+	err = tva.PruneBackups(dir, tva.BackupRetention{Count: 3, MaxAge: 150 * time.Minute}, now)
+	if !assert.Nil(t, err) {
+		return
+	}
+	backups, _ = tva.ListBackups(dir)
+	if assert.Len(t, backups, 2) {
+		assert.Equal(t, "4", backups[0].ID)
+		assert.Equal(t, "3", backups[1].ID)
+	}
+}
+
+func TestPruneLegacyBackups(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	var legacy []string
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("%s.%d", configFile, now.Add(-time.Duration(i)*time.Hour).Unix())
+		assert.Nil(t, os.WriteFile(name, []byte(`{}`), 0644))
+		legacy = append(legacy, name)
+	}
+	other := configFile + ".orig"
+	assert.Nil(t, os.WriteFile(other, []byte(`{}`), 0644))
+
+	// With 2 bundles retained only the newest legacy backup fits a retention of 3
+	err := tva.PruneLegacyBackups(configFile, tva.BackupRetention{Count: 3}, 2, now)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.FileExists(t, legacy[0])
+	assert.NoFileExists(t, legacy[1])
+	assert.NoFileExists(t, legacy[2])
+	assert.FileExists(t, other)
+
+	err = tva.PruneLegacyBackups(configFile, tva.BackupRetention{MaxAge: 30 * time.Minute}, 0, now)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NoFileExists(t, legacy[0])
+	assert.FileExists(t, other)
+}
+
+func TestRestoreBackup(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	original, err := os.ReadFile(prometheusConfig)
+	if !assert.Nil(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	if !assert.Nil(t, os.WriteFile(configFile, original, 0644)) {
+		return
+	}
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+		BackupRetention:  tva.BackupRetention{Count: 5},
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	backups, err := timeline.Backups()
+	if !assert.Nil(t, err) || !assert.Len(t, backups, 1) {
+		return
+	}
+	assert.Equal(t, uint64(0), backups[0].Reconcile)
+	assert.Equal(t, tva.GetMD5Hash(string(original)), backups[0].Hash)
+
+	handler := timeline.BackupsHandler()
+	req := httptest.NewRequest(http.MethodPost, "/backups?id=bogus", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/backups?id="+backups[0].ID, nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if !assert.Equal(t, http.StatusNoContent, resp.Code) {
+		return
+	}
+	restored, _ := os.ReadFile(configFile)
+	assert.Equal(t, string(original), string(restored))
+	assert.True(t, timeline.Paused())
+
+	// The generated config was backed up by the restore, produced by the first reconcile
+	backups, _ = timeline.Backups()
+	if assert.Len(t, backups, 2) {
+		assert.Equal(t, uint64(1), backups[0].Reconcile)
+		assert.Equal(t, tva.GetMD5Hash(output), backups[0].Hash)
+	}
+
+	// Paused reconciles leave the restored config alone
+	_, err = timeline.Reconcile()
+	assert.Nil(t, err)
+	restored, _ = os.ReadFile(configFile)
+	assert.Equal(t, string(original), string(restored))
+
+	req = httptest.NewRequest(http.MethodPost, "/backups?resume", nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.False(t, timeline.Paused())
+
+	req = httptest.NewRequest(http.MethodGet, "/backups", nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	var listing tva.BackupsResponse
+	if assert.Nil(t, json.NewDecoder(resp.Body).Decode(&listing)) {
+		assert.False(t, listing.Paused)
+		assert.Len(t, listing.Backups, 2)
+	}
+}
+
+func TestBackupHoldsOldRules(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+	defer func() { ceresRuleFor = "1m" }()
+
+	original, err := os.ReadFile(prometheusConfig)
+	if !assert.Nil(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	if !assert.Nil(t, os.WriteFile(configFile, original, 0644)) {
+		return
+	}
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+		BackupRetention:  tva.BackupRetention{Count: 5},
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	ruleFile := filepath.Join(dir, "9e22fe38-38ce-4af6-b529-44d2853d072f.yml")
+	oldRules, err := os.ReadFile(ruleFile)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ceresRuleFor = "5m"
+	_, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	newRules, _ := os.ReadFile(ruleFile)
+	if !assert.NotEqual(t, string(oldRules), string(newRules)) {
+		return
+	}
+	backups, err := timeline.Backups()
+	if !assert.Nil(t, err) || !assert.Len(t, backups, 2) {
+		return
+	}
+	assert.Equal(t, uint64(1), backups[0].Reconcile)
+	if !assert.Len(t, backups[0].RuleFiles, 1) {
+		return
+	}
+	backedUp, err := os.ReadFile(filepath.Join(dir, "backups", backups[0].ID, "rules", backups[0].RuleFiles[0]))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, string(oldRules), string(backedUp))
+}
+
+func TestRestoreOldestBackup(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	if !assert.Nil(t, os.WriteFile(configFile, []byte("# current\n"), 0644)) {
+		return
+	}
+	backupDir := filepath.Join(dir, "backups")
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3"} {
+		writeBackup(t, backupDir, tva.Backup{ID: id, Created: now.Add(-time.Duration(3-i) * time.Hour), Config: "prometheus.yml"})
+	}
+	for _, id := range []string{"1", "2"} {
+		if !assert.Nil(t, os.WriteFile(filepath.Join(backupDir, id, "prometheus.yml"), []byte("# backup "+id+"\n"), 0644)) {
+			return
+		}
+	}
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+		BackupRetention:  tva.BackupRetention{Count: 2},
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// A failed restore does not pause reconciliation
+	assert.NotNil(t, timeline.Restore("3"))
+	assert.False(t, timeline.Paused())
+
+	// The oldest backup retained is not pruned before it is restored
+	if !assert.Nil(t, timeline.Restore("1")) {
+		return
+	}
+	assert.True(t, timeline.Paused())
+	restored, _ := os.ReadFile(configFile)
+	assert.Equal(t, "# backup 1\n", string(restored))
+	backups, _ := timeline.Backups()
+	if assert.Len(t, backups, 2) {
+		assert.Equal(t, tva.GetMD5Hash("# current\n"), backups[0].Hash)
+	}
+}
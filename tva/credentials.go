@@ -0,0 +1,155 @@
+package tva
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/percona/promconfig"
+	"gopkg.in/yaml.v2"
+)
+
+// Credentials holds the named scrape credentials from the operator controlled
+// credentials file. Spaces and orgs, by GUID, can be given a default credential,
+// which only apps in that space or org get. Credentials are scoped by spaces and
+// orgs rather than by the tenant label, as app developers set that label themselves.
+type Credentials struct {
+	Credentials map[string]Credential `yaml:"credentials"`
+	Spaces      map[string]string     `yaml:"spaces,omitempty"`
+	Orgs        map[string]string     `yaml:"orgs,omitempty"`
+}
+
+// Credential holds the authentication settings used to scrape an exporter
+type Credential struct {
+	BasicAuth       *promconfig.BasicAuth `yaml:"basic_auth,omitempty"`
+	BearerTokenFile string                `yaml:"bearer_token_file,omitempty"`
+	OAuth2          *promconfig.OAuth2    `yaml:"oauth2,omitempty"`
+	// Spaces and Orgs list the GUIDs of the spaces and orgs whose apps may reference
+	// the credential by name, "*" allows all of them
+	Spaces []string `yaml:"spaces,omitempty"`
+	Orgs   []string `yaml:"orgs,omitempty"`
+	// ServiceTargets lists the target patterns of service instances the credential may be sent to
+	ServiceTargets []string `yaml:"service_targets,omitempty"`
+}
+
+// LoadCredentials reads the credentials file. A relative file is resolved
+// against the folder of the Prometheus config
+func LoadCredentials(file, prometheusConfig string) (*Credentials, error) {
+	if !path.IsAbs(file) {
+		file = path.Join(path.Dir(prometheusConfig), file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+	var credentials Credentials
+	if err := yaml.UnmarshalStrict(data, &credentials); err != nil {
+		return nil, fmt.Errorf("load credentials: %w", err)
+	}
+	for name, c := range credentials.Credentials {
+		set := 0
+		for _, configured := range []bool{c.BasicAuth != nil, c.BearerTokenFile != "", c.OAuth2 != nil} {
+			if configured {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("credential %s: exactly one of basic_auth, bearer_token_file or oauth2 is required", name)
+		}
+		for _, pattern := range c.ServiceTargets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("credential %s: service target %s: %w", name, pattern, err)
+			}
+		}
+	}
+	for space, name := range credentials.Spaces {
+		if _, ok := credentials.Credentials[name]; !ok {
+			return nil, fmt.Errorf("space %s: unknown credential %s", space, name)
+		}
+	}
+	for org, name := range credentials.Orgs {
+		if _, ok := credentials.Credentials[name]; !ok {
+			return nil, fmt.Errorf("org %s: unknown credential %s", org, name)
+		}
+	}
+	return &credentials, nil
+}
+
+// Resolve returns the named credential or, when no name is given, the default
+// credential of the space of the app, falling back to the one of its org. It returns
+// nil when neither applies. Named credentials are only available to the spaces and
+// orgs they list and to the space or org they are the default of.
+func (c *Credentials) Resolve(name string, app App) (*Credential, error) {
+	if c == nil {
+		if name != "" {
+			return nil, fmt.Errorf("%w: credential %s: no credentials file configured", ErrInvalidScrapeSettings, name)
+		}
+		return nil, nil
+	}
+	spaceDefault, orgDefault := c.Spaces[app.SpaceGUID], c.Orgs[app.OrgGUID]
+	if name == "" {
+		name = spaceDefault
+		if name == "" {
+			name = orgDefault
+		}
+		if name == "" {
+			return nil, nil
+		}
+	}
+	credential, ok := c.Credentials[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown credential %s", ErrInvalidScrapeSettings, name)
+	}
+	if spaceDefault != name && orgDefault != name && !credential.Allows(app) {
+		return nil, fmt.Errorf("%w: credential %s is not available to space %s", ErrInvalidScrapeSettings, name, app.SpaceGUID)
+	}
+	return &credential, nil
+}
+
+// Allows reports whether apps in the space or org may reference the credential by name
+func (c Credential) Allows(app App) bool {
+	return containsGUID(c.Spaces, app.SpaceGUID) || containsGUID(c.Orgs, app.OrgGUID)
+}
+
+// containsGUID reports whether the list holds the GUID or "*"
+func containsGUID(list []string, guid string) bool {
+	for _, g := range list {
+		if g == "*" || (g == guid && guid != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsTargets reports whether the credential may be sent to all targets of a service
+// instance, i.e. each target matches one of the service_targets patterns
+func (c Credential) AllowsTargets(targets []string) bool {
+	if len(targets) == 0 {
+		return false
+	}
+	for _, target := range targets {
+		allowed := false
+		for _, pattern := range c.ServiceTargets {
+			if ok, _ := path.Match(pattern, target); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply sets the authentication settings of the credential on the client config
+func (c *Credential) Apply(cfg *promconfig.HTTPClientConfig) {
+	cfg.BasicAuth = c.BasicAuth
+	cfg.OAuth2 = c.OAuth2
+	if c.BearerTokenFile != "" {
+		cfg.Authorization = &promconfig.Authorization{
+			Type:            "Bearer",
+			CredentialsFile: c.BearerTokenFile,
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package tva_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	"github.com/percona/promconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	promConfig := filepath.Join(dir, "prometheus.yml")
+	_ = os.WriteFile(filepath.Join(dir, "credentials.yml"), []byte(`credentials:
+  team-a:
+    basic_auth:
+      username: scraper
+      password_file: /secrets/team-a
+    spaces: [space-a]
+  team-b:
+    bearer_token_file: /secrets/team-b
+  team-c:
+    oauth2:
+      client_id: scraper
+      client_secret_file: /secrets/team-c
+      token_url: https://uaa.example.com/oauth/token
+    orgs: ["*"]
+  team-d:
+    bearer_token_file: /secrets/team-d
+spaces:
+  space-b: team-b
+orgs:
+  org-b: team-d
+`), 0644)
+
+	credentials, err := tva.LoadCredentials("credentials.yml", promConfig)
+	if !assert.Nil(t, err) {
+		return
+	}
+	appIn := func(space, org string) tva.App {
+		return tva.App{Application: resources.Application{SpaceGUID: space}, OrgGUID: org}
+	}
+
+	credential, err := credentials.Resolve("team-a", appIn("space-a", "org-a"))
+	if !assert.Nil(t, err) || !assert.NotNil(t, credential) {
+		return
+	}
+	var cfg promconfig.HTTPClientConfig
+	credential.Apply(&cfg)
+	if !assert.NotNil(t, cfg.BasicAuth) {
+		return
+	}
+	assert.Equal(t, "/secrets/team-a", cfg.BasicAuth.PasswordFile)
+
+	// The space default takes precedence over the org default
+	credential, err = credentials.Resolve("", appIn("space-b", "org-b"))
+	if !assert.Nil(t, err) || !assert.NotNil(t, credential) {
+		return
+	}
+	cfg = promconfig.HTTPClientConfig{}
+	credential.Apply(&cfg)
+	if !assert.NotNil(t, cfg.Authorization) {
+		return
+	}
+	assert.Equal(t, "/secrets/team-b", cfg.Authorization.CredentialsFile)
+
+	credential, err = credentials.Resolve("", appIn("space-c", "org-b"))
+	if !assert.Nil(t, err) || !assert.NotNil(t, credential) {
+		return
+	}
+	assert.Equal(t, "/secrets/team-d", credential.BearerTokenFile)
+
+	credential, err = credentials.Resolve("", appIn("space-a", "org-a"))
+	assert.Nil(t, err)
+	assert.Nil(t, credential)
+
+	_, err = credentials.Resolve("team-x", appIn("space-a", "org-a"))
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+
+	// The default credential of a space or org can be referenced by name, but only from there
+	credential, err = credentials.Resolve("team-b", appIn("space-b", "org-a"))
+	assert.Nil(t, err)
+	assert.NotNil(t, credential)
+	credential, err = credentials.Resolve("team-d", appIn("space-a", "org-b"))
+	assert.Nil(t, err)
+	assert.NotNil(t, credential)
+	_, err = credentials.Resolve("team-b", appIn("space-a", "org-a"))
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+
+	// Credentials are not available to other spaces
+	credential, err = credentials.Resolve("team-a", appIn("space-b", "org-a"))
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	assert.Nil(t, credential)
+
+	// Nor to apps without a space
+	_, err = credentials.Resolve("team-a", appIn("", "org-a"))
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+
+	credential, err = credentials.Resolve("team-c", appIn("space-b", "org-b"))
+	assert.Nil(t, err)
+	assert.NotNil(t, credential)
+
+	var none *tva.Credentials
+	_, err = none.Resolve("team-a", appIn("space-a", "org-a"))
+	assert.NotNil(t, err)
+
+	_ = os.WriteFile(filepath.Join(dir, "bogus.yml"), []byte(`credentials:
+  team-a:
+    bearer_token_file: /secrets/team-a
+    basic_auth:
+      username: scraper
+`), 0644)
+	_, err = tva.LoadCredentials(filepath.Join(dir, "bogus.yml"), promConfig)
+	assert.NotNil(t, err)
+}
+
+func TestLoadCredentialsUnknownDefault(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "credentials.yml"), []byte(`credentials:
+  team-a:
+    bearer_token_file: /secrets/team-a
+orgs:
+  org-a: team-x
+`), 0644)
+	_, err := tva.LoadCredentials(filepath.Join(dir, "credentials.yml"), filepath.Join(dir, "prometheus.yml"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,77 @@
+package tva
+
+import (
+	"fmt"
+	"strings"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+)
+
+type DomainsResponse struct {
+	Resources []Domain `json:"resources"`
+}
+
+type Domain struct {
+	GUID     string `json:"guid"`
+	Name     string `json:"name"`
+	Internal bool   `json:"internal"`
+}
+
+// splitList splits a comma separated setting, ignoring empty entries
+func splitList(list string) []string {
+	var result []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ResolveInternalDomains looks up the internal domains with the given GUIDs or names
+// and returns their names by GUID. When neither are given all internal domains of
+// the foundation are returned. Unknown and non-internal domains are an error.
+func ResolveInternalDomains(session *clients.Session, guids, names []string) (map[string]string, error) {
+	var domains DomainsResponse
+	err := RawRetrieve(session.Raw(), "/v3/domains?per_page=5000", &domains)
+	if err != nil {
+		return nil, fmt.Errorf("domains lookup: %w", err)
+	}
+	resolved := make(map[string]string)
+	lookup := func(kind, value string, match func(Domain) bool) error {
+		for _, d := range domains.Resources {
+			if !match(d) {
+				continue
+			}
+			if !d.Internal {
+				return fmt.Errorf("domain %s (%s) is not an internal domain", d.Name, d.GUID)
+			}
+			resolved[d.GUID] = d.Name
+			return nil
+		}
+		return fmt.Errorf("internal domain with %s %s not found", kind, value)
+	}
+	for _, guid := range guids {
+		guid := guid
+		if err := lookup("guid", guid, func(d Domain) bool { return d.GUID == guid }); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		name := name
+		if err := lookup("name", name, func(d Domain) bool { return d.Name == name }); err != nil {
+			return nil, err
+		}
+	}
+	if len(guids) == 0 && len(names) == 0 {
+		for _, d := range domains.Resources {
+			if d.Internal {
+				resolved[d.GUID] = d.Name
+			}
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("no internal domain found")
+	}
+	return resolved, nil
+}
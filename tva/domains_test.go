@@ -0,0 +1,42 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveInternalDomains(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	session, err := clients.NewSession(clients.Config{
+		Endpoint: serverCF.URL,
+		User:     "ron",
+		Password: "swanson",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	expected := map[string]string{internalDomainID: "apps.internal"}
+
+	domains, err := tva.ResolveInternalDomains(session, nil, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, expected, domains)
+	}
+	domains, err = tva.ResolveInternalDomains(session, []string{internalDomainID}, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, expected, domains)
+	}
+	domains, err = tva.ResolveInternalDomains(session, nil, []string{"apps.internal"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, expected, domains)
+	}
+
+	_, err = tva.ResolveInternalDomains(session, nil, []string{"example.com"})
+	assert.NotNil(t, err)
+	_, err = tva.ResolveInternalDomains(session, []string{"7c1e7a3a-0000-0000-0000-000000000000"}, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,72 @@
+package tva
+
+import (
+	"fmt"
+	"strings"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+)
+
+// envAnnotations are the annotations which can also be set as app environment variable
+var envAnnotations = []string{
+	AnnotationInstanceName,
+	AnnotationInstanceSourceRegex,
+	AnnotationRelabelConfigs,
+	AnnotationMetricRelabelConfigs,
+	AnnotationExporterPort,
+	AnnotationExporterPath,
+	AnnotationExporterScheme,
+	AnnotationExporterJobName,
+	AnnotationExporterProcessType,
+	AnnotationExporterSidecar,
+	AnnotationExporterCredentials,
+	AnnotationTLSCAFile,
+	AnnotationTLSCertFile,
+	AnnotationTLSKeyFile,
+	AnnotationTLSServerName,
+	AnnotationTLSInsecureSkipVerify,
+	AnnotationExporterScrapInterval,
+	AnnotationExporterScrapeTimeout,
+	AnnotationExporterHonorLabels,
+	AnnotationExporterSampleLimit,
+	AnnotationExporterLabelLimit,
+	AnnotationExporterTargetLimit,
+	AnnotationExporterBodySizeLimit,
+	AnnotationExporterProtocols,
+	AnnotationScrapeMode,
+	AnnotationExportersJSON,
+}
+
+type EnvironmentVariablesResponse struct {
+	Var map[string]string `json:"var"`
+}
+
+// EnvName returns the environment variable name of an annotation,
+// e.g. prometheus.exporter.tls.ca_file becomes VARIANT_EXPORTER_TLS_CA_FILE
+func EnvName(annotation string) string {
+	name := strings.TrimPrefix(annotation, "prometheus.")
+	return "VARIANT_" + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+}
+
+// EnvAnnotations maps the VARIANT_EXPORTER_* style environment variables onto their annotations
+func EnvAnnotations(env map[string]string) map[string]*string {
+	annotations := make(map[string]*string)
+	for _, annotation := range envAnnotations {
+		if value, ok := env[EnvName(annotation)]; ok {
+			value := value
+			annotations[annotation] = &value
+		}
+	}
+	return annotations
+}
+
+// EnvAnnotationsRetrieve returns the annotations set through the environment variables of an app.
+// This requires permission to read the environment variables of the app.
+func EnvAnnotationsRetrieve(client *clients.RawClient, appGUID string) (map[string]*string, error) {
+	var env EnvironmentVariablesResponse
+	err := RawRetrieve(client, fmt.Sprintf("/v3/apps/%s/environment_variables", appGUID), &env)
+	if err != nil {
+		return nil, fmt.Errorf("environment variables: %w", err)
+	}
+	return EnvAnnotations(env.Var), nil
+}
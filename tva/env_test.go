@@ -0,0 +1,47 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "VARIANT_EXPORTER_PORT", tva.EnvName(tva.AnnotationExporterPort))
+	assert.Equal(t, "VARIANT_EXPORTER_TLS_CA_FILE", tva.EnvName(tva.AnnotationTLSCAFile))
+	assert.Equal(t, "VARIANT_EXPORTERS_JSON", tva.EnvName(tva.AnnotationExportersJSON))
+}
+
+func TestEnvAnnotations(t *testing.T) {
+	annotations := tva.EnvAnnotations(map[string]string{
+		"VARIANT_EXPORTER_PORT":            "9100",
+		"VARIANT_EXPORTER_SCRAPE_INTERVAL": "1m",
+		"VARIANT_RULES_JSON":               "[]",
+		"PATH":                             "/usr/bin",
+	})
+	if !assert.Len(t, annotations, 2) {
+		return
+	}
+	assert.Equal(t, "9100", *annotations[tva.AnnotationExporterPort])
+	assert.Equal(t, "1m", *annotations[tva.AnnotationExporterScrapInterval])
+
+	// App annotations take precedence over environment variables, which take precedence over the space
+	port := "8080"
+	spaceInterval := "30s"
+	app := tva.App{
+		SpaceAnnotations: map[string]*string{
+			tva.AnnotationExporterScrapInterval: &spaceInterval,
+		},
+		EnvAnnotations: annotations,
+	}
+	metadata, sources := app.EffectiveMetadata(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationExporterPort: &port,
+		},
+	})
+	assert.Equal(t, "8080", *metadata.Annotations[tva.AnnotationExporterPort])
+	assert.Equal(t, tva.MetadataLevelApp, sources[tva.AnnotationExporterPort])
+	assert.Equal(t, "1m", *metadata.Annotations[tva.AnnotationExporterScrapInterval])
+	assert.Equal(t, tva.MetadataLevelEnv, sources[tva.AnnotationExporterScrapInterval])
+}
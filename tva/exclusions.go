@@ -0,0 +1,82 @@
+package tva
+
+import (
+	"fmt"
+	"path"
+
+	"code.cloudfoundry.org/cli/resources"
+)
+
+// Exclusions are deny-lists of glob patterns, matched against both the GUID and the name
+type Exclusions struct {
+	Apps   []string
+	Spaces []string
+	Orgs   []string
+}
+
+// ParseExclusions parses a comma separated list of glob patterns
+func ParseExclusions(patterns string) ([]string, error) {
+	list := splitList(patterns)
+	for _, pattern := range list {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("exclusion pattern %s: %w", pattern, err)
+		}
+	}
+	return list, nil
+}
+
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if matched, _ := path.Match(pattern, value); matched && value != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Excluded reports whether the app is excluded from discovery, either through the
+// exclude label or because the app, its space or its org is on a deny-list
+func (e Exclusions) Excluded(app resources.Application, space resources.Space, org resources.Organization) bool {
+	if app.Metadata != nil {
+		if exclude, ok := app.Metadata.Labels[ExcludeLabel]; ok && exclude.IsSet && exclude.Value == "true" {
+			return true
+		}
+	}
+	return matchAny(e.Apps, app.GUID, app.Name) ||
+		matchAny(e.Spaces, space.GUID, space.Name) ||
+		matchAny(e.Orgs, org.GUID, org.Name)
+}
+
+// bySpaceOrOrg reports whether excluding needs the space and org of an app
+func (e Exclusions) bySpaceOrOrg() bool {
+	return len(e.Spaces) > 0 || len(e.Orgs) > 0
+}
+
+// withoutExcluded returns the apps which are not excluded. Space and org names
+// are resolved through the org and space cache. Apps whose space or org cannot
+// be looked up are skipped for this cycle, as they might be excluded.
+func (t *Timeline) withoutExcluded(apps []resources.Application) []resources.Application {
+	var result []resources.Application
+	for _, app := range apps {
+		var space resources.Space
+		var org resources.Organization
+		if t.exclusions.bySpaceOrOrg() {
+			var err error
+			org, space, err = t.LookupOrgAndSpace(app.SpaceGUID)
+			if err != nil {
+				fmt.Printf("app %s (%s): skipped, exclusions: %v\n", app.Name, app.GUID, err)
+				continue
+			}
+		}
+		if t.exclusions.Excluded(t.config.prefixes.Application(app), space, org) {
+			if t.debug {
+				fmt.Printf("app %s (%s): excluded\n", app.Name, app.GUID)
+			}
+			continue
+		}
+		result = append(result, app)
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	"code.cloudfoundry.org/cli/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExclusions(t *testing.T) {
+	apps, err := tva.ParseExclusions("legacy-*, 9e22fe38-38ce-4af6-b529-44d2853d072f")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, apps, 2)
+	_, err = tva.ParseExclusions("[legacy")
+	assert.NotNil(t, err)
+
+	exclusions := tva.Exclusions{
+		Apps: apps,
+		Orgs: []string{"sandbox-*"},
+	}
+	space := resources.Space{GUID: "space-guid", Name: "test-space"}
+	org := resources.Organization{GUID: "org-guid", Name: "test-org"}
+
+	assert.True(t, exclusions.Excluded(resources.Application{GUID: "a", Name: "legacy-billing"}, space, org))
+	assert.True(t, exclusions.Excluded(resources.Application{GUID: "9e22fe38-38ce-4af6-b529-44d2853d072f", Name: "ceres"}, space, org))
+	assert.False(t, exclusions.Excluded(resources.Application{GUID: "b", Name: "billing"}, space, org))
+	assert.True(t, exclusions.Excluded(resources.Application{GUID: "b", Name: "billing"}, space, resources.Organization{Name: "sandbox-ron"}))
+
+	excluded := resources.Application{
+		GUID: "c",
+		Name: "ceres",
+		Metadata: &resources.Metadata{Labels: map[string]types.NullString{
+			tva.ExcludeLabel: types.NewNullString("true"),
+		}},
+	}
+	assert.True(t, tva.Exclusions{}.Excluded(excluded, space, org))
+	excluded.Metadata.Labels[tva.ExcludeLabel] = types.NewNullString("false")
+	assert.False(t, tva.Exclusions{}.Excluded(excluded, space, org))
+}
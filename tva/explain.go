@@ -0,0 +1,93 @@
+package tva
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3"
+)
+
+// Explanation shows the effective annotations of an app and the level each was taken from
+type Explanation struct {
+	GUID        string                    `json:"guid"`
+	Name        string                    `json:"name"`
+	Space       string                    `json:"space"`
+	Org         string                    `json:"org"`
+	Annotations map[string]ExplainedValue `json:"annotations"`
+}
+
+type ExplainedValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Explain returns the effective annotations of an app. It returns nil when the app is not found
+func (t *Timeline) Explain(appGUID string) (*Explanation, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	session, err := t.session()
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	apps, _, err := session.V3().GetApplications(ccv3.Query{
+		Key:    ccv3.GUIDFilter,
+		Values: []string{appGUID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetApplications: %w", err)
+	}
+	if len(apps) == 0 {
+		return nil, nil
+	}
+	origin := t.origin(apps[0])
+	metadata, err := MetadataRetrieve(session.Raw(), appGUID)
+	if err != nil {
+		return nil, fmt.Errorf("metadataRetrieve: %w", err)
+	}
+	effective, sources := origin.EffectiveMetadata(t.config.prefixes.Metadata(metadata))
+	explanation := &Explanation{
+		GUID:        origin.GUID,
+		Name:        origin.Name,
+		Space:       origin.SpaceName,
+		Org:         origin.OrgName,
+		Annotations: make(map[string]ExplainedValue),
+	}
+	for key, value := range effective.Annotations {
+		if value == nil {
+			continue
+		}
+		explanation.Annotations[key] = ExplainedValue{
+			Value:  *value,
+			Source: sources[key],
+		}
+	}
+	return explanation, nil
+}
+
+// ExplainHandler serves the effective annotations of the app given by the app query parameter
+func (t *Timeline) ExplainHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		appGUID := r.URL.Query().Get("app")
+		if appGUID == "" {
+			http.Error(w, "missing app parameter", http.StatusBadRequest)
+			return
+		}
+		explanation, err := t.Explain(appGUID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if explanation == nil {
+			http.Error(w, "app not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(explanation)
+	}
+}
@@ -0,0 +1,78 @@
+package tva_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveMetadata(t *testing.T) {
+	interval := "1m"
+	spaceInterval := "30s"
+	port := "8080"
+	rules := "[]"
+	app := tva.App{
+		OrgAnnotations: map[string]*string{
+			tva.AnnotationExporterScrapInterval: &interval,
+			tva.AnnotationRulesJSON:             &rules,
+			"owner":                             &rules,
+		},
+		SpaceAnnotations: map[string]*string{
+			tva.AnnotationExporterScrapInterval: &spaceInterval,
+		},
+	}
+	metadata, sources := app.EffectiveMetadata(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationExporterPort: &port,
+		},
+	})
+	assert.Equal(t, "30s", *metadata.Annotations[tva.AnnotationExporterScrapInterval])
+	assert.Equal(t, tva.MetadataLevelSpace, sources[tva.AnnotationExporterScrapInterval])
+	assert.Equal(t, tva.MetadataLevelApp, sources[tva.AnnotationExporterPort])
+	assert.Nil(t, metadata.Annotations[tva.AnnotationRulesJSON])
+	assert.Nil(t, metadata.Annotations["owner"])
+}
+
+func TestExplainHandler(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}, tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	handler := timeline.ExplainHandler()
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/explain", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/explain?app=9e22fe38-38ce-4af6-b529-44d2853d072f", nil))
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+	var explanation tva.Explanation
+	if !assert.Nil(t, json.NewDecoder(rec.Body).Decode(&explanation)) {
+		return
+	}
+	assert.Equal(t, "ceres", explanation.Name)
+	assert.Equal(t, tva.ExplainedValue{Value: "8080", Source: "app"}, explanation.Annotations[tva.AnnotationExporterPort])
+	assert.Equal(t, tva.ExplainedValue{Value: "5", Source: "org"}, explanation.Annotations[tva.AnnotationAutoscalerMax])
+	assert.Equal(t, "app", explanation.Annotations[tva.AnnotationRulesJSON].Source)
+}
@@ -0,0 +1,131 @@
+package tva
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/percona/promconfig"
+)
+
+const fileSDExtension = ".json"
+
+// targetFilesManifest lists the target files variant wrote, so it only ever
+// removes its own files from the file_sd folder
+const targetFilesManifest = ".variant-targets.json"
+
+// targetFileRE matches the <job>-<guid8>.json target files written before the manifest existed
+var targetFileRE = regexp.MustCompile(`^[^.].*-([0-9a-f]{8})\.json$`)
+
+// fileSDFolder returns the folder target files are written to. Relative
+// folders are resolved against the folder of the Prometheus config, just like
+// Prometheus does when it reads file_sd_configs.
+func (t *Timeline) fileSDFolder() string {
+	if path.IsAbs(t.fileSDDir) {
+		return t.fileSDDir
+	}
+	return path.Join(path.Dir(t.config.PrometheusConfig), t.fileSDDir)
+}
+
+// fileSDPaths returns the path variant writes a target file to and the path
+// to reference from prometheus.yml. Job names which would end up outside of the
+// folder, or as a hidden file such as the manifest, are refused.
+func (t *Timeline) fileSDPaths(jobName string) (string, string, error) {
+	name := jobName + fileSDExtension
+	folder := filepath.Clean(t.fileSDFolder())
+	diskPath := filepath.Join(folder, name)
+	if strings.HasPrefix(name, ".") || filepath.Base(diskPath) != name || filepath.Dir(diskPath) != folder {
+		return "", "", fmt.Errorf("target file %q is not a file in %s", name, folder)
+	}
+	return diskPath, path.Join(t.fileSDDir, name), nil
+}
+
+// writeTargetFiles writes the static targets of each scrape config to a
+// file_sd target file and returns the scrape configs rewritten to reference
+// these files. Target files of jobs which are no longer present are removed.
+// The origins are the apps discovered this reconcile, only their legacy target
+// files are taken over when there is no manifest yet.
+func (t *Timeline) writeTargetFiles(configs []ScrapeConfig, origins map[string]App) ([]ScrapeConfig, error) {
+	var rewritten []ScrapeConfig
+	owned := make(map[string]bool)
+	files := make(map[string][]byte)
+
+	if err := os.MkdirAll(t.fileSDFolder(), 0755); err != nil {
+		return nil, fmt.Errorf("create target files folder: %w", err)
+	}
+
+	for _, cfg := range configs {
+		if len(cfg.ServiceDiscoveryConfig.StaticConfigs) == 0 { // e.g. http_sd based multi host scraping
+			rewritten = append(rewritten, cfg)
+			continue
+		}
+		var groups []TargetGroup
+		for _, g := range cfg.ServiceDiscoveryConfig.StaticConfigs {
+			groups = append(groups, TargetGroup{
+				Targets: g.Targets,
+				Labels:  g.Labels,
+			})
+		}
+		data, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("marshal targets %s: %w", cfg.JobName, err)
+		}
+		diskPath, reference, err := t.fileSDPaths(cfg.JobName)
+		if err != nil {
+			fmt.Printf("job %s: %v\n", cfg.JobName, err)
+			continue
+		}
+		owned[filepath.Base(diskPath)] = true
+		files[diskPath] = data
+		cfg.ServiceDiscoveryConfig.StaticConfigs = nil
+		cfg.ServiceDiscoveryConfig.FileSDConfigs = []*promconfig.FilesSDConfig{
+			{Files: []string{reference}},
+		}
+		rewritten = append(rewritten, cfg)
+	}
+	var names []string
+	for n := range owned {
+		names = append(names, n)
+	}
+	if err := t.targetFilesManifest(origins).track(names...); err != nil {
+		t.incWriteErrors()
+		return nil, fmt.Errorf("target files: %w", err)
+	}
+	for diskPath, data := range files {
+		if err := writeFileIfChanged(diskPath, data, 0644); err != nil {
+			t.incWriteErrors()
+			return nil, fmt.Errorf("save targets %s: %w", diskPath, err)
+		}
+	}
+	return rewritten, t.pruneTargetFiles(owned, origins)
+}
+
+// targetFilesManifest returns the manifest of the file_sd folder. Without a manifest
+// only <job>-<guid8>.json files of the apps in origins are taken over, as the folder
+// may hold files of others matching that pattern.
+func (t *Timeline) targetFilesManifest(origins map[string]App) manifest {
+	guids := make(map[string]bool)
+	for _, origin := range origins {
+		guids[strings.Split(origin.GUID, "-")[0]] = true
+	}
+	return manifest{
+		dir:  t.fileSDFolder(),
+		name: targetFilesManifest,
+		legacy: func(name string) bool {
+			m := targetFileRE.FindStringSubmatch(name)
+			return m != nil && guids[m[1]]
+		},
+	}
+}
+
+// pruneTargetFiles removes target files of apps which disappeared from the timeline
+func (t *Timeline) pruneTargetFiles(owned map[string]bool, origins map[string]App) error {
+	if err := t.targetFilesManifest(origins).prune(owned, t.debug); err != nil {
+		return fmt.Errorf("target files: %w", err)
+	}
+	return nil
+}
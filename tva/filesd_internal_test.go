@@ -0,0 +1,39 @@
+package tva
+
+import (
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/cli/resources"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSDPaths(t *testing.T) {
+	dir := t.TempDir()
+	timeline := &Timeline{fileSDDir: dir}
+
+	diskPath, reference, err := timeline.fileSDPaths("ceres-9e22fe38")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, filepath.Join(dir, "ceres-9e22fe38.json"), diskPath)
+	assert.Equal(t, filepath.Join(dir, "ceres-9e22fe38.json"), reference)
+
+	for _, jobName := range []string{"../../etc/x", "sub/ceres", ".variant-targets", ".."} {
+		_, _, err = timeline.fileSDPaths(jobName)
+		assert.NotNil(t, err, jobName)
+	}
+}
+
+func TestTargetFilesManifestLegacy(t *testing.T) {
+	timeline := &Timeline{fileSDDir: t.TempDir()}
+	origins := map[string]App{
+		"ceres-9e22fe38": {Application: resources.Application{GUID: "9e22fe38-38ce-4af6-b529-44d2853d072f"}},
+	}
+
+	legacy := timeline.targetFilesManifest(origins).legacy
+	assert.True(t, legacy("vesta-9e22fe38.json"))
+	assert.False(t, legacy("gone-12345678.json"))
+	assert.False(t, legacy(".hidden-9e22fe38.json"))
+	assert.False(t, legacy("hosts.json"))
+}
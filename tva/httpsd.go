@@ -0,0 +1,75 @@
+package tva
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// TargetGroups returns the discovered targets as target groups, optionally
+// filtered by tenant and space. The space can be given as name or GUID.
+// Scrape settings which Prometheus supports as target labels are
+// added to each group so a single http_sd_configs job can scrape all targets.
+// Jobs with settings that cannot be passed as target labels are left out.
+func (t *Timeline) TargetGroups(tenant, space string) []TargetGroup {
+	t.targetsMu.RLock()
+	defer t.targetsMu.RUnlock()
+
+	defaults := defaultHTTPClientConfig(t.config)
+	groups := []TargetGroup{}
+	for _, cfg := range t.targets {
+		origin, ok := t.origins[cfg.JobName]
+		if !ok || !cfg.Discoverable(defaults) {
+			continue
+		}
+		if tenant != "" && AppTenant(origin.Application) != tenant {
+			continue
+		}
+		if space != "" && space != origin.SpaceGUID && space != origin.SpaceName {
+			continue
+		}
+		for _, g := range cfg.ServiceDiscoveryConfig.StaticConfigs {
+			labels := map[string]string{
+				"job": cfg.JobName,
+			}
+			if cfg.MetricsPath != "" {
+				labels["__metrics_path__"] = cfg.MetricsPath
+			}
+			if cfg.Scheme != "" {
+				labels["__scheme__"] = cfg.Scheme
+			}
+			if cfg.ScrapeInterval != 0 {
+				labels["__scrape_interval__"] = cfg.ScrapeInterval.String()
+			}
+			if cfg.ScrapeTimeout != 0 {
+				labels["__scrape_timeout__"] = cfg.ScrapeTimeout.String()
+			}
+			for k, v := range g.Labels {
+				labels[k] = v
+			}
+			groups = append(groups, TargetGroup{
+				Targets: g.Targets,
+				Labels:  labels,
+			})
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Labels["job"] < groups[j].Labels["job"]
+	})
+	return groups
+}
+
+// ServiceDiscoveryHandler serves the discovered targets in the Prometheus
+// http_sd_configs format. The tenant and space query parameters filter the list
+func (t *Timeline) ServiceDiscoveryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		groups := t.TargetGroups(query.Get("tenant"), query.Get("space"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(groups)
+	}
+}
@@ -0,0 +1,77 @@
+package tva
+
+import (
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/cli/resources"
+	"code.cloudfoundry.org/cli/types"
+	"github.com/percona/promconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetGroups(t *testing.T) {
+	newConfig := func(name string, targets ...string) ScrapeConfig {
+		cfg := ScrapeConfig{ScrapeConfig: promconfig.ScrapeConfig{
+			JobName:          name,
+			MetricsPath:      "/metrics",
+			Scheme:           "http",
+			ScrapeInterval:   promconfig.Duration(30 * time.Second),
+			ScrapeTimeout:    promconfig.Duration(10 * time.Second),
+			HTTPClientConfig: defaultHTTPClientConfig(Config{}),
+		}}
+		cfg.ServiceDiscoveryConfig.StaticConfigs = []*promconfig.Group{
+			{Targets: targets, Labels: map[string]string{"cf_app_name": name}},
+		}
+		return cfg
+	}
+	relabeled := newConfig("relabeled-22222222", "0.relabeled.apps.internal:8080")
+	relabeled.MetricRelabelConfigs = []*promconfig.RelabelConfig{
+		{TargetLabel: "instance", SourceLabels: []string{"instance"}, Replacement: "relabeled", Action: "replace"},
+	}
+	other := newConfig("other-33333333", "0.other.apps.internal:8080")
+	tenantLabels := map[string]types.NullString{TenantLabel: types.NewNullString("other")}
+
+	timeline := &Timeline{
+		targets: []ScrapeConfig{
+			newConfig("ceres-11111111", "0.ceres.apps.internal:8080"),
+			relabeled,
+			other,
+		},
+		origins: map[string]App{
+			"ceres-11111111":     {Application: resources.Application{SpaceGUID: "space-guid"}, SpaceName: "test-space"},
+			"relabeled-22222222": {Application: resources.Application{SpaceGUID: "space-guid"}, SpaceName: "test-space"},
+			"other-33333333": {
+				Application: resources.Application{
+					SpaceGUID: "other-space-guid",
+					Metadata:  &resources.Metadata{Labels: tenantLabels},
+				},
+				SpaceName: "other-space",
+			},
+		},
+	}
+
+	groups := timeline.TargetGroups("default", "test-space")
+	if !assert.Len(t, groups, 1) {
+		return
+	}
+	assert.Equal(t, []string{"0.ceres.apps.internal:8080"}, groups[0].Targets)
+	assert.Equal(t, "ceres-11111111", groups[0].Labels["job"])
+	assert.Equal(t, "/metrics", groups[0].Labels["__metrics_path__"])
+	assert.Equal(t, "http", groups[0].Labels["__scheme__"])
+	assert.Equal(t, "30s", groups[0].Labels["__scrape_interval__"])
+	assert.Equal(t, "10s", groups[0].Labels["__scrape_timeout__"])
+	assert.Equal(t, "ceres-11111111", groups[0].Labels["cf_app_name"])
+
+	groups = timeline.TargetGroups("other", "")
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "other-33333333", groups[0].Labels["job"])
+	}
+	assert.Len(t, timeline.TargetGroups("", "space-guid"), 1)
+	assert.Len(t, timeline.TargetGroups("", ""), 2)
+
+	// Serving targets does not wait for a reconcile
+	timeline.Lock()
+	defer timeline.Unlock()
+	assert.Len(t, timeline.TargetGroups("", ""), 2)
+}
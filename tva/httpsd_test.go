@@ -0,0 +1,60 @@
+package tva_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceDiscoveryHandler(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	config := tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}
+	timeline, err := tva.NewTimeline(config,
+		tva.WithTenants("default"),
+		tva.WithReload(false),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	handler := timeline.ServiceDiscoveryHandler()
+
+	// The relabel_configs of ceres cannot be passed through http_sd
+	if !assert.Len(t, timeline.Targets(), 1) {
+		return
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/sd/targets?tenant=default&space=test-space", nil))
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+	var groups []tva.TargetGroup
+	if !assert.Nil(t, json.NewDecoder(rec.Body).Decode(&groups)) {
+		return
+	}
+	assert.Len(t, groups, 0)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/sd/targets", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
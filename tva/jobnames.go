@@ -0,0 +1,158 @@
+package tva
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/resources"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// JobNamingName names jobs after the job_name annotation or the app name, followed by
+	// the first segment of the app GUID. Renaming the app changes its job name.
+	JobNamingName = "name"
+	// JobNamingGUID names jobs after the app GUID, which never changes
+	JobNamingGUID = "guid"
+	// JobNamingTemplate names jobs using the configured job name template
+	JobNamingTemplate = "template"
+	// JobNamingAnnotation names jobs after the job_name annotation as is, falling back to the app GUID
+	JobNamingAnnotation = "annotation"
+
+	// DefaultJobNameTemplate is used with the template strategy when no template is configured
+	DefaultJobNameTemplate = "{org}-{space}-{app}"
+)
+
+// jobNamePlaceholders are the placeholders supported in job name templates
+var jobNamePlaceholders = []string{"{org}", "{space}", "{app}", "{app_guid}", "{job}"}
+
+// ValidateJobNaming checks the job naming strategy and, for the template strategy, its template
+func ValidateJobNaming(strategy, template string) error {
+	switch strategy {
+	case "", JobNamingName, JobNamingGUID, JobNamingAnnotation:
+		return nil
+	case JobNamingTemplate:
+		if template == "" {
+			return nil
+		}
+		rest := template
+		for _, placeholder := range jobNamePlaceholders {
+			rest = strings.ReplaceAll(rest, placeholder, "")
+		}
+		if strings.ContainsAny(rest, "{}") {
+			return fmt.Errorf("unknown placeholder in job name template: %s", template)
+		}
+		if rest == template {
+			return fmt.Errorf("job name template contains no placeholders: %s", template)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown job naming strategy: %s", strategy)
+}
+
+// jobNameSafe replaces characters which do not belong in a job name, which
+// is also used as file name for file_sd target files
+func jobNameSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// JobName returns the job name of an app according to the configured naming strategy.
+// The suffix distinguishes multiple jobs of the same app, e.g. per exporter or for probes.
+// Whatever the strategy, characters which do not belong in a job name are replaced.
+func JobName(config Config, app App, metadata Metadata, suffix string) string {
+	job := app.Name // Default
+	annotated := false
+	if name := metadata.Annotations[AnnotationExporterJobName]; name != nil && *name != "" {
+		job = *name
+		annotated = true
+	}
+	var name string
+	switch config.JobNaming {
+	case JobNamingGUID:
+		name = app.GUID
+	case JobNamingAnnotation:
+		name = app.GUID
+		if annotated {
+			name = job
+		}
+	case JobNamingTemplate:
+		template := config.JobNameTemplate
+		if template == "" {
+			template = DefaultJobNameTemplate
+		}
+		name = strings.NewReplacer(
+			"{org}", app.OrgName,
+			"{space}", app.SpaceName,
+			"{app}", app.Name,
+			"{app_guid}", app.GUID,
+			"{job}", job,
+		).Replace(template)
+	default:
+		appGUID := strings.Split(app.GUID, "-")[0] // Ensure uniqueness across spaces
+		if suffix != "" {
+			return jobNameSafe(fmt.Sprintf("%s-%s-%s", job, suffix, appGUID))
+		}
+		return jobNameSafe(fmt.Sprintf("%s-%s", job, appGUID))
+	}
+	if suffix != "" {
+		name = fmt.Sprintf("%s-%s", name, suffix)
+	}
+	return jobNameSafe(name)
+}
+
+// ClaimJobNames claims the job names of the configs for the owner. Configs with a job name
+// already claimed, by another owner or by an earlier config of the same owner, e.g. two
+// exporters with the same job_suffix, are left out and reported as ErrInvalidScrapeSettings,
+// as Prometheus refuses a config with duplicate job names. Owners claim in GUID order, see
+// byGUID, so the same owner keeps a contested name on every reconcile.
+func ClaimJobNames(claimed map[string]string, owner string, configs []ScrapeConfig) ([]ScrapeConfig, error) {
+	var result []ScrapeConfig
+	var collisions []string
+	for _, cfg := range configs {
+		if other, ok := claimed[cfg.JobName]; ok {
+			if other == owner {
+				collisions = append(collisions, fmt.Sprintf("%s (used more than once)", cfg.JobName))
+			} else {
+				collisions = append(collisions, fmt.Sprintf("%s (claimed by %s)", cfg.JobName, other))
+			}
+			continue
+		}
+		claimed[cfg.JobName] = owner
+		result = append(result, cfg)
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return result, fmt.Errorf("%w: duplicate job names: %s", ErrInvalidScrapeSettings, strings.Join(collisions, ", "))
+	}
+	return result, nil
+}
+
+// byGUID sorts apps by GUID, so job names are claimed in the same order on every reconcile
+// regardless of the order the CF API returns apps in
+func byGUID(apps []resources.Application) []resources.Application {
+	sorted := append([]resources.Application(nil), apps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GUID < sorted[j].GUID
+	})
+	return sorted
+}
+
+// staticJobNames returns the job names of the scrape configs in a Prometheus config,
+// claimed by the owner given
+func staticJobNames(config string, owner string) map[string]string {
+	claimed := make(map[string]string)
+	var cfg PrometheusConfig
+	if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+		return claimed
+	}
+	for _, sc := range cfg.ScrapeConfigs {
+		claimed[sc.JobName] = owner
+	}
+	return claimed
+}
@@ -0,0 +1,90 @@
+package tva_test
+
+import (
+	"errors"
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobName(t *testing.T) {
+	app := tva.App{
+		Application: resources.Application{
+			GUID: "9e22fe38-6e6c-4cf7-a2bc-1ba5b1d8c1a2",
+			Name: "ceres",
+		},
+		SpaceName: "belt",
+		OrgName:   "opa",
+	}
+	jobName := "protomolecule"
+	annotated := tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationExporterJobName: &jobName,
+	}}
+
+	assert.Equal(t, "ceres-9e22fe38", tva.JobName(tva.Config{}, app, tva.Metadata{}, ""))
+	assert.Equal(t, "protomolecule-9e22fe38", tva.JobName(tva.Config{}, app, annotated, ""))
+	assert.Equal(t, "ceres-probe-9e22fe38", tva.JobName(tva.Config{}, app, tva.Metadata{}, "probe"))
+	spaced := "proto molecule/v2"
+	assert.Equal(t, "proto_molecule_v2-9e22fe38", tva.JobName(tva.Config{}, app, tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationExporterJobName: &spaced,
+	}}, ""))
+
+	config := tva.Config{JobNaming: tva.JobNamingGUID}
+	assert.Equal(t, app.GUID, tva.JobName(config, app, annotated, ""))
+	assert.Equal(t, app.GUID+"-9100", tva.JobName(config, app, annotated, "9100"))
+
+	config = tva.Config{JobNaming: tva.JobNamingAnnotation}
+	assert.Equal(t, "protomolecule", tva.JobName(config, app, annotated, ""))
+	assert.Equal(t, app.GUID, tva.JobName(config, app, tva.Metadata{}, ""))
+	escape := "../../etc/x"
+	assert.Equal(t, ".._.._etc_x", tva.JobName(config, app, tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationExporterJobName: &escape,
+	}}, ""))
+
+	config = tva.Config{JobNaming: tva.JobNamingTemplate}
+	assert.Equal(t, "opa-belt-ceres", tva.JobName(config, app, annotated, ""))
+	config.JobNameTemplate = "{space}/{job}"
+	assert.Equal(t, "belt_protomolecule-probe", tva.JobName(config, app, annotated, "probe"))
+}
+
+func TestValidateJobNaming(t *testing.T) {
+	assert.Nil(t, tva.ValidateJobNaming("", ""))
+	assert.Nil(t, tva.ValidateJobNaming(tva.JobNamingGUID, ""))
+	assert.Nil(t, tva.ValidateJobNaming(tva.JobNamingTemplate, ""))
+	assert.Nil(t, tva.ValidateJobNaming(tva.JobNamingTemplate, "{org}-{app}"))
+	assert.NotNil(t, tva.ValidateJobNaming(tva.JobNamingTemplate, "{org}-{name}"))
+	assert.NotNil(t, tva.ValidateJobNaming(tva.JobNamingTemplate, "static"))
+	assert.NotNil(t, tva.ValidateJobNaming("random", ""))
+}
+
+func TestClaimJobNames(t *testing.T) {
+	newConfig := func(name string) tva.ScrapeConfig {
+		var cfg tva.ScrapeConfig
+		cfg.JobName = name
+		return cfg
+	}
+	claimed := map[string]string{"prometheus": "static config"}
+
+	configs, err := tva.ClaimJobNames(claimed, "app-1", []tva.ScrapeConfig{newConfig("ceres"), newConfig("eros")})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, configs, 2)
+
+	// Duplicates of the same owner, e.g. an exporter with job_suffix probe next to a probe job
+	configs, err = tva.ClaimJobNames(claimed, "app-3", []tva.ScrapeConfig{newConfig("titan-probe"), newConfig("titan-probe"), newConfig("titan")})
+	assert.True(t, errors.Is(err, tva.ErrInvalidScrapeSettings))
+	if assert.Len(t, configs, 2) {
+		assert.Equal(t, "titan-probe", configs[0].JobName)
+		assert.Equal(t, "titan", configs[1].JobName)
+	}
+
+	configs, err = tva.ClaimJobNames(claimed, "app-2", []tva.ScrapeConfig{newConfig("eros"), newConfig("prometheus"), newConfig("ganymede")})
+	assert.True(t, errors.Is(err, tva.ErrInvalidScrapeSettings))
+	if assert.Len(t, configs, 1) {
+		assert.Equal(t, "ganymede", configs[0].JobName)
+	}
+	assert.Equal(t, "app-1", claimed["eros"])
+}
@@ -0,0 +1,102 @@
+package tva
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/cli/resources"
+)
+
+const (
+	// LabelSourceApp copies the labels of the app onto its targets
+	LabelSourceApp = "app"
+	// LabelSourceSpace copies the labels of the space of the app onto its targets
+	LabelSourceSpace = "space"
+	// LabelSourceOrg copies the labels of the org of the app onto its targets
+	LabelSourceOrg = "org"
+
+	copiedLabelPrefix  = "cf_label_"
+	variantLabelPrefix = "variant.tva/"
+)
+
+// ParseLabelSources parses a comma separated list of label sources
+func ParseLabelSources(sources string) ([]string, error) {
+	var result []string
+	for _, source := range splitList(sources) {
+		switch source {
+		case LabelSourceApp, LabelSourceSpace, LabelSourceOrg:
+			result = append(result, source)
+		default:
+			return nil, fmt.Errorf("unknown label source: %s", source)
+		}
+	}
+	return result, nil
+}
+
+// LabelName turns a CF label key into a valid Prometheus label name
+// by replacing all unsupported characters with an underscore
+func LabelName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// copyLabels adds the labels as cf_label_<name> to the target labels.
+// The labels variant uses for its own selection, prefixed or not, are left out.
+func copyLabels(target map[string]string, labels map[string]string) {
+	for key, value := range labels {
+		if strings.Contains(key, variantLabelPrefix) {
+			continue
+		}
+		target[copiedLabelPrefix+LabelName(key)] = value
+	}
+}
+
+// resourceLabels returns the labels of a CF resource, skipping unset ones
+func resourceLabels(metadata *resources.Metadata) map[string]string {
+	labels := make(map[string]string)
+	if metadata == nil {
+		return labels
+	}
+	for key, value := range metadata.Labels {
+		if value.IsSet {
+			labels[key] = value.Value
+		}
+	}
+	return labels
+}
+
+// TargetLabels returns the labels which are added to all targets of an app.
+// Copied labels of the app take precedence over those of its space, which
+// in turn take precedence over those of its org.
+func TargetLabels(config Config, app App, appLabels map[string]*string) map[string]string {
+	labels := map[string]string{
+		"cf_app_name":   app.Name,
+		"cf_space_name": app.SpaceName,
+		"cf_org_name":   app.OrgName,
+	}
+	if config.GUIDLabels {
+		labels["cf_app_guid"] = app.GUID
+		labels["cf_space_guid"] = app.SpaceGUID
+		labels["cf_org_guid"] = app.OrgGUID
+	}
+	if ContainsString(config.LabelSources, LabelSourceOrg) {
+		copyLabels(labels, app.OrgLabels)
+	}
+	if ContainsString(config.LabelSources, LabelSourceSpace) {
+		copyLabels(labels, app.SpaceLabels)
+	}
+	if ContainsString(config.LabelSources, LabelSourceApp) {
+		copied := make(map[string]string)
+		for key, value := range appLabels {
+			if value != nil {
+				copied[key] = *value
+			}
+		}
+		copyLabels(labels, copied)
+	}
+	return labels
+}
@@ -0,0 +1,77 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelName(t *testing.T) {
+	assert.Equal(t, "team", tva.LabelName("team"))
+	assert.Equal(t, "example_com_cost_center", tva.LabelName("example.com/cost-center"))
+}
+
+func TestParseLabelSources(t *testing.T) {
+	sources, err := tva.ParseLabelSources("app, space,org")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"app", "space", "org"}, sources)
+
+	sources, err = tva.ParseLabelSources("")
+	assert.Nil(t, err)
+	assert.Len(t, sources, 0)
+
+	_, err = tva.ParseLabelSources("app,foundation")
+	assert.NotNil(t, err)
+}
+
+func TestTargetLabels(t *testing.T) {
+	team := "payments"
+	env := "prod"
+	exporter := "true"
+	app := tva.App{
+		Application: resources.Application{
+			GUID:      "app-guid",
+			Name:      "app",
+			SpaceGUID: "space-guid",
+		},
+		SpaceName:   "space",
+		OrgName:     "org",
+		OrgGUID:     "org-guid",
+		OrgLabels:   map[string]string{"env": "dev", "region": "eu"},
+		SpaceLabels: map[string]string{"env": "test"},
+	}
+	appLabels := map[string]*string{
+		"team":                 &team,
+		"env":                  &env,
+		"variant.tva/exporter": &exporter,
+	}
+
+	labels := tva.TargetLabels(tva.Config{}, app, appLabels)
+	assert.Equal(t, map[string]string{
+		"cf_app_name":   "app",
+		"cf_space_name": "space",
+		"cf_org_name":   "org",
+	}, labels)
+
+	labels = tva.TargetLabels(tva.Config{
+		LabelSources: []string{tva.LabelSourceApp, tva.LabelSourceSpace, tva.LabelSourceOrg},
+		GUIDLabels:   true,
+	}, app, appLabels)
+	assert.Equal(t, "app-guid", labels["cf_app_guid"])
+	assert.Equal(t, "space-guid", labels["cf_space_guid"])
+	assert.Equal(t, "org-guid", labels["cf_org_guid"])
+	assert.Equal(t, "payments", labels["cf_label_team"])
+	assert.Equal(t, "prod", labels["cf_label_env"])
+	assert.Equal(t, "eu", labels["cf_label_region"])
+	_, ok := labels["cf_label_variant_tva_exporter"]
+	assert.False(t, ok)
+
+	labels = tva.TargetLabels(tva.Config{LabelSources: []string{tva.LabelSourceSpace, tva.LabelSourceOrg}}, app, appLabels)
+	assert.Equal(t, "test", labels["cf_label_env"])
+	_, ok = labels["cf_label_team"]
+	assert.False(t, ok)
+}
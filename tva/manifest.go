@@ -0,0 +1,96 @@
+package tva
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// manifest lists the files variant wrote to a folder, so it knows which files to
+// remove once they are no longer needed without touching any other files in there
+type manifest struct {
+	dir    string
+	name   string
+	legacy func(name string) bool // Files written before the manifest existed
+}
+
+// owned returns the files variant wrote. Without a manifest the files the legacy
+// func accepts are assumed to be ours.
+func (m manifest) owned() (map[string]bool, error) {
+	owned := make(map[string]bool)
+	data, err := os.ReadFile(path.Join(m.dir, m.name))
+	if errors.Is(err, os.ErrNotExist) {
+		entries, err := os.ReadDir(m.dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read %s: %w", m.dir, err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && m.legacy(e.Name()) {
+				owned[e.Name()] = true
+			}
+		}
+		return owned, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %w", m.name, err)
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", m.name, err)
+	}
+	for _, n := range names {
+		owned[n] = true
+	}
+	return owned, nil
+}
+
+func (m manifest) save(owned map[string]bool) error {
+	names := make([]string, 0, len(owned))
+	for n := range owned {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest %s: %w", m.name, err)
+	}
+	return writeFileIfChanged(path.Join(m.dir, m.name), data, 0644)
+}
+
+// track adds files to the manifest before they are written,
+// so they are cleaned up even when variant stops halfway
+func (m manifest) track(names ...string) error {
+	owned, err := m.owned()
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		owned[n] = true
+	}
+	return m.save(owned)
+}
+
+// prune removes the files variant wrote which are not in keep
+func (m manifest) prune(keep map[string]bool, debug bool) error {
+	owned, err := m.owned()
+	if err != nil {
+		return err
+	}
+	for n := range owned {
+		if keep[n] {
+			continue
+		}
+		if debug {
+			fmt.Printf("removing stale file %s\n", path.Join(m.dir, n))
+		}
+		err := os.Remove(path.Join(m.dir, n))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", n, err)
+		}
+		delete(owned, n)
+	}
+	return m.save(owned)
+}
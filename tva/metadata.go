@@ -1,7 +1,17 @@
 package tva
 
+import "strings"
+
 type metadataType string
 
+// The levels annotations can be set at, the app level taking precedence
+const (
+	MetadataLevelApp   = "app"
+	MetadataLevelEnv   = "env"
+	MetadataLevelSpace = "space"
+	MetadataLevelOrg   = "org"
+)
+
 type MetadataRequest struct {
 	Metadata Metadata `json:"metadata"`
 }
@@ -10,3 +20,43 @@ type Metadata struct {
 	Labels      map[string]*string `json:"labels,omitempty"`
 	Annotations map[string]*string `json:"annotations,omitempty"`
 }
+
+// defaultable reports whether an annotation can be set as default on a space or org.
+// Rules are left out as they would be duplicated for every app.
+func defaultable(key string) bool {
+	if key == AnnotationRulesJSON || AnnotationRulesIndexJSONRegex.MatchString(key) {
+		return false
+	}
+	return strings.HasPrefix(key, "prometheus.") || strings.HasPrefix(key, "variant.")
+}
+
+// EffectiveMetadata returns the metadata of the app with the annotations set through its
+// environment variables, space and org merged in as defaults, in that order of precedence,
+// together with the level each annotation was taken from
+func (a App) EffectiveMetadata(metadata Metadata) (Metadata, map[string]string) {
+	effective := Metadata{
+		Labels:      metadata.Labels,
+		Annotations: make(map[string]*string),
+	}
+	sources := make(map[string]string)
+	for _, level := range []struct {
+		name        string
+		annotations map[string]*string
+	}{
+		{MetadataLevelOrg, a.OrgAnnotations},
+		{MetadataLevelSpace, a.SpaceAnnotations},
+		{MetadataLevelEnv, a.EnvAnnotations},
+	} {
+		for key, value := range level.annotations {
+			if value != nil && defaultable(key) {
+				effective.Annotations[key] = value
+				sources[key] = level.name
+			}
+		}
+	}
+	for key, value := range metadata.Annotations {
+		effective.Annotations[key] = value
+		sources[key] = MetadataLevelApp
+	}
+	return effective, sources
+}
@@ -4,9 +4,14 @@ type Metrics interface {
 	SetScrapeInterval(float64)
 	SetManagedNetworkPolicies(float64)
 	SetDetectedScrapeConfigs(float64)
+	SetExcludedInstances(float64)
+	SetInvalidApps(float64)
 	IncTotalIncursions()
 	IncErrorIncursions()
 	IncConfigLoads()
 	IncConfigCacheHits()
 	IncOutOfBoundChanges()
+	IncWriteErrors()
+	IncReloadFailures()
+	SetQuarantinedRules([]QuarantinedRules)
 }
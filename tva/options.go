@@ -1,7 +1,6 @@
 package tva
 
 import (
-	"fmt"
 	"strings"
 	"time"
 )
@@ -31,6 +30,24 @@ func WithReload(reload bool) OptionFunc {
 	}
 }
 
+// WithFileSD writes discovered targets to file_sd target files in dir instead of
+// embedding them as static_configs. An empty dir disables this mode
+func WithFileSD(dir string) OptionFunc {
+	return func(t *Timeline) error {
+		t.fileSDDir = dir
+		return nil
+	}
+}
+
+// WithRulesDir writes the rule files of apps to dir instead of the folder of the
+// Prometheus config. A relative dir is resolved against the folder of the config
+func WithRulesDir(dir string) OptionFunc {
+	return func(t *Timeline) error {
+		t.rulesDir = dir
+		return nil
+	}
+}
+
 func WithMetrics(metrics Metrics) OptionFunc {
 	return func(t *Timeline) error {
 		t.metrics = metrics
@@ -50,6 +67,33 @@ func WithSpaces(spaces string) OptionFunc {
 	}
 }
 
+// WithExcludeApps excludes the apps matching the comma separated GUID or name glob patterns
+func WithExcludeApps(apps string) OptionFunc {
+	return func(t *Timeline) error {
+		var err error
+		t.exclusions.Apps, err = ParseExclusions(apps)
+		return err
+	}
+}
+
+// WithExcludeSpaces excludes the apps in the spaces matching the comma separated GUID or name glob patterns
+func WithExcludeSpaces(spaces string) OptionFunc {
+	return func(t *Timeline) error {
+		var err error
+		t.exclusions.Spaces, err = ParseExclusions(spaces)
+		return err
+	}
+}
+
+// WithExcludeOrgs excludes the apps in the orgs matching the comma separated GUID or name glob patterns
+func WithExcludeOrgs(orgs string) OptionFunc {
+	return func(t *Timeline) error {
+		var err error
+		t.exclusions.Orgs, err = ParseExclusions(orgs)
+		return err
+	}
+}
+
 func WithTenants(tenants string) OptionFunc {
 	var vetted []string
 	var isDefault bool
@@ -63,10 +107,23 @@ func WithTenants(tenants string) OptionFunc {
 	}
 	return func(t *Timeline) error {
 		t.defaultTenant = isDefault
-		if len(vetted) > 0 {
-			tenants = strings.Join(vetted, ",")
-			t.Selectors = append(t.Selectors, fmt.Sprintf("%s in (%s)", TenantLabel, tenants))
-		}
+		t.tenants = vetted
+		return nil
+	}
+}
+
+// WithLabelPrefix prepends prefix to the variant labels, e.g. obs-prod.variant.tva/exporter
+func WithLabelPrefix(prefix string) OptionFunc {
+	return func(t *Timeline) error {
+		t.config.prefixes.Label = prefix
+		return nil
+	}
+}
+
+// WithAnnotationPrefix prepends prefix to the variant annotations, e.g. obs-prod/prometheus.exporter.port
+func WithAnnotationPrefix(prefix string) OptionFunc {
+	return func(t *Timeline) error {
+		t.config.prefixes.Annotation = prefix
 		return nil
 	}
 }
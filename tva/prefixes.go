@@ -0,0 +1,113 @@
+package tva
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/cli/resources"
+	"code.cloudfoundry.org/cli/types"
+)
+
+// annotationFamilies are the annotation key prefixes owned by variant
+var annotationFamilies = []string{"prometheus.", "variant."}
+
+// KeyPrefixes are prepended to the label and annotation keys variant uses, so independent
+// variants on the same foundation never pick up each other's apps. The empty prefixes
+// result in the default keys.
+type KeyPrefixes struct {
+	Label      string
+	Annotation string
+}
+
+// LabelKey returns the key of a variant label with the prefix applied
+func (p KeyPrefixes) LabelKey(label string) string {
+	return p.Label + label
+}
+
+// AnnotationKey returns the key of a variant annotation with the prefix applied
+func (p KeyPrefixes) AnnotationKey(annotation string) string {
+	return p.Annotation + annotation
+}
+
+// canonicalLabel returns the default key of a label. Unprefixed variant labels belong to
+// another variant and are not kept.
+func (p KeyPrefixes) canonicalLabel(key string) (string, bool) {
+	if p.Label == "" {
+		return key, true
+	}
+	if strings.HasPrefix(key, p.Label+variantLabelPrefix) {
+		return strings.TrimPrefix(key, p.Label), true
+	}
+	return key, !strings.HasPrefix(key, variantLabelPrefix)
+}
+
+// canonicalAnnotation returns the default key of an annotation. Unprefixed variant
+// annotations belong to another variant and are not kept.
+func (p KeyPrefixes) canonicalAnnotation(key string) (string, bool) {
+	if p.Annotation == "" {
+		return key, true
+	}
+	if strings.HasPrefix(key, p.Annotation) {
+		rest := strings.TrimPrefix(key, p.Annotation)
+		for _, family := range annotationFamilies {
+			if strings.HasPrefix(rest, family) {
+				return rest, true
+			}
+		}
+	}
+	for _, family := range annotationFamilies {
+		if strings.HasPrefix(key, family) {
+			return key, false
+		}
+	}
+	return key, true
+}
+
+// Metadata returns the metadata with the prefixed keys replaced by the default keys
+// and the keys of other variants left out
+func (p KeyPrefixes) Metadata(metadata Metadata) Metadata {
+	if p.Label == "" && p.Annotation == "" {
+		return metadata
+	}
+	return Metadata{
+		Labels:      p.canonicalKeys(metadata.Labels, p.canonicalLabel),
+		Annotations: p.canonicalKeys(metadata.Annotations, p.canonicalAnnotation),
+	}
+}
+
+// Annotations returns the annotations with the prefixed keys replaced by the default keys
+func (p KeyPrefixes) Annotations(annotations map[string]*string) map[string]*string {
+	if p.Annotation == "" {
+		return annotations
+	}
+	return p.canonicalKeys(annotations, p.canonicalAnnotation)
+}
+
+func (p KeyPrefixes) canonicalKeys(values map[string]*string, canonical func(string) (string, bool)) map[string]*string {
+	if values == nil {
+		return nil
+	}
+	result := make(map[string]*string)
+	for key, value := range values {
+		if key, ok := canonical(key); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// Application returns a copy of the app with the prefixed label keys replaced by the default keys
+func (p KeyPrefixes) Application(app resources.Application) resources.Application {
+	if p.Label == "" || app.Metadata == nil {
+		return app
+	}
+	labels := make(map[string]types.NullString)
+	for key, value := range app.Metadata.Labels {
+		if key, ok := p.canonicalLabel(key); ok {
+			labels[key] = value
+		}
+	}
+	metadata := *app.Metadata
+	metadata.Labels = labels
+	app.Metadata = &metadata
+	return app
+}
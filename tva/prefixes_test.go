@@ -0,0 +1,83 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	"code.cloudfoundry.org/cli/types"
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPrefixesMetadata(t *testing.T) {
+	prod := "9100"
+	staging := "9200"
+	team := "data"
+	prefixes := tva.KeyPrefixes{
+		Label:      "obs-prod.",
+		Annotation: "obs-prod/",
+	}
+	assert.Equal(t, "obs-prod.variant.tva/exporter", prefixes.LabelKey(tva.ExporterLabel))
+	assert.Equal(t, "obs-prod/prometheus.exporter.port", prefixes.AnnotationKey(tva.AnnotationExporterPort))
+
+	metadata := prefixes.Metadata(tva.Metadata{
+		Labels: map[string]*string{
+			"obs-prod.variant.tva/tenant": &team,
+			"variant.tva/tenant":          &staging,
+			"team":                        &team,
+		},
+		Annotations: map[string]*string{
+			"obs-prod/prometheus.exporter.port": &prod,
+			"prometheus.exporter.port":          &staging,
+			"owner":                             &team,
+		},
+	})
+	assert.Equal(t, "9100", *metadata.Annotations[tva.AnnotationExporterPort])
+	assert.Equal(t, "data", *metadata.Annotations["owner"])
+	assert.Len(t, metadata.Annotations, 2)
+	assert.Equal(t, "data", *metadata.Labels[tva.TenantLabel])
+	assert.Equal(t, "data", *metadata.Labels["team"])
+	assert.Len(t, metadata.Labels, 2)
+
+	// Default prefixes leave the metadata as is
+	metadata = tva.KeyPrefixes{}.Metadata(tva.Metadata{Annotations: map[string]*string{
+		"prometheus.exporter.port": &staging,
+	}})
+	assert.Equal(t, "9200", *metadata.Annotations[tva.AnnotationExporterPort])
+
+	app := prefixes.Application(resources.Application{
+		Metadata: &resources.Metadata{Labels: map[string]types.NullString{
+			"obs-prod.variant.tva/tenant": types.NewNullString("data"),
+			"variant.tva/tenant":          types.NewNullString("staging"),
+		}},
+	})
+	assert.Equal(t, "data", tva.AppTenant(app))
+}
+
+func TestPrefixSelectors(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	},
+		tva.WithTenants("data,default"),
+		tva.WithLabelPrefix("obs-prod."),
+		tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{
+		"obs-prod.variant.tva/exporter=true",
+		"obs-prod.variant.tva/tenant in (data)",
+	}, timeline.Selectors)
+}
@@ -0,0 +1,155 @@
+package tva
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/percona/promconfig"
+)
+
+const probePath = "/probe"
+
+// Probe describes a blackbox exporter check of one of the routes of an app
+type Probe struct {
+	Target   string `json:"target"`
+	Module   string `json:"module"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// ParseProbes reads the probes of an app. Probes without target or module are
+// skipped, the valid ones are still returned together with an ErrInvalidScrapeSettings error.
+func ParseProbes(metadata Metadata) ([]Probe, error) {
+	var probes []Probe
+	probeJSON := metadata.Annotations[AnnotationProbeJSON]
+	if probeJSON == nil {
+		return probes, nil
+	}
+	err := json.NewDecoder(bytes.NewBufferString(*probeJSON)).Decode(&probes)
+	if err != nil {
+		return nil, invalidScrapeSettings(fmt.Errorf("decoding probe JSON: %w", err))
+	}
+	var valid []Probe
+	var invalid []error
+	for i, p := range probes {
+		if p.Target == "" || p.Module == "" {
+			invalid = append(invalid, fmt.Errorf("probe %d: target and module are required", i))
+			continue
+		}
+		valid = append(valid, p)
+	}
+	return valid, invalidScrapeSettings(invalid...)
+}
+
+// routeHost returns the hostname of a probe target, which can be given with or without scheme
+func routeHost(target string) (string, error) {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
+
+// ownRoute checks whether the target is one of the routes of the app, so apps
+// cannot use the blackbox exporter to probe arbitrary hosts
+func ownRoute(target string, routes []Route, app App) bool {
+	host, err := routeHost(target)
+	if err != nil {
+		return false
+	}
+	for _, r := range routes {
+		routeHost, _, _ := strings.Cut(r.URL, "/")
+		if !strings.EqualFold(routeHost, host) {
+			continue
+		}
+		for _, d := range r.Destinations {
+			if d.App.GUID == app.GUID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GenerateProbeConfig returns the scrape config named jobName which probes the targets
+// through the blackbox exporter. Probes which are not allowed are skipped and reported in the error.
+func GenerateProbeConfig(config Config, app App, jobName string, probes []Probe, routes []Route, labels map[string]string) (*ScrapeConfig, error) {
+	if config.BlackboxExporter == "" {
+		return nil, fmt.Errorf("probes: no blackbox exporter configured")
+	}
+	var invalid []error
+	var groups []*promconfig.Group
+	for i, p := range probes {
+		if !knownModule(config.BlackboxModules, p.Module) {
+			invalid = append(invalid, fmt.Errorf("probe %d: module %s is not allowed", i, p.Module))
+			continue
+		}
+		if !ownRoute(p.Target, routes, app) {
+			invalid = append(invalid, fmt.Errorf("probe %d: %s is not a route of the app", i, p.Target))
+			continue
+		}
+		groupLabels := map[string]string{
+			"__param_module": p.Module,
+		}
+		if p.Interval != "" {
+			var interval promconfig.Duration
+			if err := interval.Set(p.Interval); err != nil {
+				invalid = append(invalid, fmt.Errorf("probe %d: %w", i, err))
+				continue
+			}
+			groupLabels["__scrape_interval__"] = interval.String()
+		}
+		for k, v := range labels {
+			groupLabels[k] = v
+		}
+		groups = append(groups, &promconfig.Group{
+			Targets: []string{p.Target},
+			Labels:  groupLabels,
+		})
+	}
+	if len(groups) == 0 {
+		return nil, errors.Join(invalid...)
+	}
+	scrapeConfig := &ScrapeConfig{ScrapeConfig: promconfig.ScrapeConfig{
+		JobName: jobName,
+		HTTPClientConfig: promconfig.HTTPClientConfig{
+			FollowRedirects: true,
+		},
+		HonorTimestamps: true,
+		MetricsPath:     probePath,
+		ServiceDiscoveryConfig: promconfig.ServiceDiscoveryConfig{
+			StaticConfigs: groups,
+		},
+		RelabelConfigs: []*promconfig.RelabelConfig{
+			{
+				SourceLabels: []string{"__address__"},
+				TargetLabel:  "__param_target",
+			},
+			{
+				SourceLabels: []string{"__param_target"},
+				TargetLabel:  "instance",
+			},
+			{
+				TargetLabel: "__address__",
+				Replacement: config.BlackboxExporter,
+			},
+		},
+	}}
+	return scrapeConfig, errors.Join(invalid...)
+}
+
+// knownModule is case-sensitive, just like the blackbox exporter
+func knownModule(modules string, module string) bool {
+	for _, m := range splitList(modules) {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProbes(t *testing.T) {
+	probeJSON := `[{"target": "https://app.example.com/health", "module": "http_2xx", "interval": "1m"}]`
+	probes, err := tva.ParseProbes(tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationProbeJSON: &probeJSON,
+	}})
+	if !assert.Nil(t, err) || !assert.Len(t, probes, 1) {
+		return
+	}
+	assert.Equal(t, "http_2xx", probes[0].Module)
+
+	// Probes missing a target or module are skipped, the others are kept
+	missingModule := `[{"target": "app.example.com"}, {"target": "app.example.com:443", "module": "tcp_connect"}, {"module": "http_2xx"}]`
+	probes, err = tva.ParseProbes(tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationProbeJSON: &missingModule,
+	}})
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	if assert.Len(t, probes, 1) {
+		assert.Equal(t, "tcp_connect", probes[0].Module)
+	}
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "probe 0")
+		assert.Contains(t, err.Error(), "probe 2")
+	}
+
+	bogus := `{`
+	_, err = tva.ParseProbes(tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationProbeJSON: &bogus,
+	}})
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+}
+
+func TestGenerateProbeConfig(t *testing.T) {
+	app := tva.App{Application: resources.Application{GUID: "9e22fe38-38ce-4af6-b529-44d2853d072f", Name: "ceres"}}
+	route := tva.Route{URL: "ceres.example.com"}
+	destination := tva.RouteDestination{}
+	destination.App.GUID = app.GUID
+	route.Destinations = []tva.RouteDestination{destination}
+	config := tva.Config{
+		BlackboxExporter: "blackbox.apps.internal:9115",
+		BlackboxModules:  "http_2xx, tcp_connect",
+	}
+	probes := []tva.Probe{
+		{Target: "https://ceres.example.com/health", Module: "http_2xx", Interval: "1m"},
+		{Target: "ceres.example.com:443", Module: "tcp_connect"},
+		{Target: "https://other.example.com", Module: "http_2xx"},
+		{Target: "https://ceres.example.com", Module: "icmp"},
+	}
+	labels := map[string]string{"cf_app_name": "ceres"}
+
+	cfg, err := tva.GenerateProbeConfig(config, app, "ceres-probe-9e22fe38", probes, []tva.Route{route}, labels)
+	assert.NotNil(t, err)
+	if !assert.NotNil(t, cfg) {
+		return
+	}
+	assert.Equal(t, "ceres-probe-9e22fe38", cfg.JobName)
+	assert.Equal(t, "/probe", cfg.MetricsPath)
+	assert.False(t, cfg.Discoverable(cfg.HTTPClientConfig))
+	groups := cfg.ServiceDiscoveryConfig.StaticConfigs
+	if !assert.Len(t, groups, 2) {
+		return
+	}
+	assert.Equal(t, []string{"https://ceres.example.com/health"}, groups[0].Targets)
+	assert.Equal(t, "http_2xx", groups[0].Labels["__param_module"])
+	assert.Equal(t, "1m", groups[0].Labels["__scrape_interval__"])
+	assert.Equal(t, "ceres", groups[0].Labels["cf_app_name"])
+	assert.Equal(t, "tcp_connect", groups[1].Labels["__param_module"])
+	if assert.Len(t, cfg.RelabelConfigs, 3) {
+		assert.Equal(t, "blackbox.apps.internal:9115", cfg.RelabelConfigs[2].Replacement)
+	}
+
+	_, err = tva.GenerateProbeConfig(tva.Config{}, app, "ceres-probe-9e22fe38", probes, []tva.Route{route}, labels)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,98 @@
+package tva
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/percona/promconfig"
+)
+
+const (
+	// ScrapeModeInternal scrapes instances directly over the internal domain, which requires network policies
+	ScrapeModeInternal = "internal"
+	// ScrapeModePublic scrapes instances through the gorouter over a public route
+	ScrapeModePublic = "public"
+
+	// HeaderCFAppInstance makes the gorouter route a request to a specific app instance
+	HeaderCFAppInstance = "X-CF-APP-INSTANCE"
+
+	defaultAppPort = 8080
+	publicPort     = 443
+)
+
+// ParseScrapeMode returns the scrape mode of an app
+func ParseScrapeMode(metadata Metadata) (string, error) {
+	mode := metadata.Annotations[AnnotationScrapeMode]
+	if mode == nil || *mode == "" {
+		return ScrapeModeInternal, nil
+	}
+	switch *mode {
+	case ScrapeModeInternal, ScrapeModePublic:
+		return *mode, nil
+	}
+	return "", fmt.Errorf("%s: unknown scrape mode %s", AnnotationScrapeMode, *mode)
+}
+
+// PublicHosts returns the public hostname of each process type of an app
+// which has a route to the given port. Routes on internal domains and routes
+// with a path are skipped.
+func PublicHosts(routes []Route, domains map[string]string, app App, port int) map[string]string {
+	hosts := make(map[string]string)
+	for _, r := range routes {
+		if _, internal := domains[r.Relationships.Domain.Data.GUID]; internal || r.Path != "" || r.URL == "" {
+			continue
+		}
+		for _, d := range r.Destinations {
+			destinationPort := d.Port
+			if destinationPort == 0 {
+				destinationPort = defaultAppPort
+			}
+			if d.App.GUID != app.GUID || destinationPort != port {
+				continue
+			}
+			processType := d.App.Process.Type
+			if processType == "" {
+				processType = defaultProcessType
+			}
+			if _, ok := hosts[processType]; !ok {
+				hosts[processType] = r.URL
+			}
+		}
+	}
+	return hosts
+}
+
+// instanceScrapeConfigs splits a scrape config into one scrape config per
+// instance. The gorouter routes each scrape to its instance based on the
+// X-CF-APP-INSTANCE header. The job and instance labels are kept the same
+// as with internal scraping.
+func instanceScrapeConfigs(cfg ScrapeConfig, appGUID string) []ScrapeConfig {
+	var configs []ScrapeConfig
+	for _, g := range cfg.ServiceDiscoveryConfig.StaticConfigs {
+		for _, target := range g.Targets {
+			index, address, found := strings.Cut(target, ".")
+			if !found {
+				continue
+			}
+			labels := map[string]string{
+				"job":      cfg.JobName,
+				"instance": target,
+			}
+			for k, v := range g.Labels {
+				labels[k] = v
+			}
+			instance := cfg
+			instance.JobName = fmt.Sprintf("%s-%s", cfg.JobName, index)
+			instance.ServiceDiscoveryConfig = promconfig.ServiceDiscoveryConfig{
+				StaticConfigs: []*promconfig.Group{
+					{Targets: []string{address}, Labels: labels},
+				},
+			}
+			instance.HTTPHeaders = map[string]HTTPHeader{
+				HeaderCFAppInstance: {Values: []string{fmt.Sprintf("%s:%s", appGUID, index)}},
+			}
+			configs = append(configs, instance)
+		}
+	}
+	return configs
+}
@@ -0,0 +1,67 @@
+package tva
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3"
+	"code.cloudfoundry.org/cli/resources"
+	"code.cloudfoundry.org/cli/types"
+	"github.com/percona/promconfig"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPublicHosts(t *testing.T) {
+	app := App{Application: resources.Application{GUID: "app-guid"}}
+	domains := map[string]string{"internal-guid": "apps.internal"}
+	route := func(url, path, domain string, port int) Route {
+		r := Route{URL: url, Path: path}
+		r.Relationships.Domain.Data.GUID = domain
+		d := RouteDestination{Port: port}
+		d.App.GUID = app.GUID
+		r.Destinations = []RouteDestination{d}
+		return r
+	}
+	routes := []Route{
+		route("app.apps.internal", "", "internal-guid", 0),
+		route("app.example.com/api", "/api", "public-guid", 0),
+		route("app.example.com", "", "public-guid", 0),
+		route("app-metrics.example.com", "", "public-guid", 9090),
+	}
+
+	assert.Equal(t, map[string]string{"web": "app.example.com"}, PublicHosts(routes, domains, app, 8080))
+	assert.Equal(t, map[string]string{"web": "app-metrics.example.com"}, PublicHosts(routes, domains, app, 9090))
+	assert.Len(t, PublicHosts(routes, domains, app, 9100), 0)
+}
+
+func TestInstanceScrapeConfigs(t *testing.T) {
+	processes := []ccv3.Process{
+		{GUID: "web-guid", Type: "web", Instances: types.NullInt{IsSet: true, Value: 2}},
+	}
+	hosts := map[string]string{"web": "app.example.com"}
+	groups := processTargetGroups(processes, hosts, instanceStates{index: true}, Exporter{Port: publicPort}, map[string]string{"cf_app_name": "app"})
+
+	configs := instanceScrapeConfigs(ScrapeConfig{ScrapeConfig: promconfig.ScrapeConfig{
+		JobName: "app-1234",
+		Scheme:  "https",
+		ServiceDiscoveryConfig: promconfig.ServiceDiscoveryConfig{
+			StaticConfigs: groups,
+		},
+	}}, "app-guid")
+	if !assert.Len(t, configs, 2) {
+		return
+	}
+	assert.Equal(t, "app-1234-1", configs[1].JobName)
+	group := configs[1].ServiceDiscoveryConfig.StaticConfigs[0]
+	assert.Equal(t, []string{"app.example.com:443"}, group.Targets)
+	assert.Equal(t, "app-1234", group.Labels["job"])
+	assert.Equal(t, "1.app.example.com:443", group.Labels["instance"])
+	assert.Equal(t, "app", group.Labels["cf_app_name"])
+	assert.Equal(t, []string{"app-guid:1"}, configs[1].HTTPHeaders[HeaderCFAppInstance].Values)
+
+	data, err := yaml.Marshal(configs[0])
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Contains(t, string(data), "http_headers:\n  X-CF-APP-INSTANCE:\n    values:\n    - app-guid:0\n")
+}
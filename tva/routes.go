@@ -0,0 +1,240 @@
+package tva
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3"
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3/constant"
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/percona/promconfig"
+)
+
+const (
+	defaultProcessType   = "web"
+	instanceStateUnknown = "UNKNOWN"
+)
+
+const (
+	// InstanceStatesDrop excludes instances which are not running from the targets
+	InstanceStatesDrop = "drop"
+	// InstanceStatesLabel adds a cf_instance_state label to each target
+	InstanceStatesLabel = "label"
+)
+
+type RoutesResponse struct {
+	Resources []Route `json:"resources"`
+}
+
+type Route struct {
+	GUID          string             `json:"guid"`
+	Host          string             `json:"host"`
+	Path          string             `json:"path"`
+	URL           string             `json:"url"`
+	Destinations  []RouteDestination `json:"destinations"`
+	Relationships struct {
+		Domain struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"domain"`
+	} `json:"relationships"`
+}
+
+type RouteDestination struct {
+	App struct {
+		GUID    string `json:"guid"`
+		Process struct {
+			Type string `json:"type"`
+		} `json:"process"`
+	} `json:"app"`
+	Port int `json:"port,omitempty"`
+}
+
+// AppRoutes returns all routes which have the app as one of their destinations
+func AppRoutes(session *clients.Session, app App) ([]Route, error) {
+	var routes RoutesResponse
+	err := RawRetrieve(session.Raw(), fmt.Sprintf("/v3/apps/%s/routes?per_page=5000", app.GUID), &routes)
+	if err != nil {
+		return nil, err
+	}
+	return routes.Resources, nil
+}
+
+// InternalHosts returns the internal hostname of each process type of an app.
+// Processes are reachable through a route on one of the internal domains which
+// has the process type as its destination, "web" being the default.
+func InternalHosts(session *clients.Session, domains map[string]string, app App) (map[string]string, error) {
+	routes, err := AppRoutes(session, app)
+	if err != nil {
+		return nil, err
+	}
+	return internalRouteHosts(routes, domains, app)
+}
+
+func internalRouteHosts(routes []Route, domains map[string]string, app App) (map[string]string, error) {
+	hosts := make(map[string]string)
+	for _, r := range routes {
+		domain, ok := domains[r.Relationships.Domain.Data.GUID]
+		if !ok {
+			continue
+		}
+		for _, d := range r.Destinations {
+			if d.App.GUID != app.GUID {
+				continue
+			}
+			processType := d.App.Process.Type
+			if processType == "" {
+				processType = defaultProcessType
+			}
+			if _, ok := hosts[processType]; !ok {
+				hosts[processType] = fmt.Sprintf("%s.%s", r.Host, domain)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return hosts, fmt.Errorf("no internal route found")
+	}
+	return hosts, nil
+}
+
+// InternalHost returns the internal hostname of the web process, or of any
+// other process when the app has no internal route to its web process
+func InternalHost(session *clients.Session, domains map[string]string, app App) (string, error) {
+	hosts, err := InternalHosts(session, domains, app)
+	if err != nil {
+		return "", err
+	}
+	return primaryHost(hosts), nil
+}
+
+func primaryHost(hosts map[string]string) string {
+	if host, ok := hosts[defaultProcessType]; ok {
+		return host
+	}
+	var types []string
+	for processType := range hosts {
+		types = append(types, processType)
+	}
+	sort.Strings(types)
+	return hosts[types[0]]
+}
+
+// instanceStates holds the state of each instance, per process GUID
+type instanceStates struct {
+	mode   string
+	states map[string]map[int]string
+	index  bool // Target group per instance, labeled with its index
+}
+
+func (s instanceStates) state(processGUID string, index int) string {
+	if state, ok := s.states[processGUID][index]; ok {
+		return state
+	}
+	return instanceStateUnknown
+}
+
+func (s instanceStates) running(processGUID string, index int) bool {
+	return s.state(processGUID, index) == string(constant.ProcessInstanceRunning)
+}
+
+// excluded returns the number of reachable instances which are excluded from scraping
+func (s instanceStates) excluded(processes []ccv3.Process, hosts map[string]string) int {
+	if s.mode != InstanceStatesDrop {
+		return 0
+	}
+	excluded := 0
+	for _, p := range processes {
+		if _, ok := hosts[p.Type]; !ok || !p.Instances.IsSet {
+			continue
+		}
+		for count := 0; count < p.Instances.Value; count++ {
+			if !s.running(p.GUID, count) {
+				excluded++
+			}
+		}
+	}
+	return excluded
+}
+
+// processTargetGroups returns target groups for each process type the exporter
+// applies to. Process types without an internal route are not reachable and skipped.
+// Depending on the instance states mode instances which are not running are excluded
+// or get their state as label, in which case a group is returned per state.
+// When the index is requested a group is returned per instance instead.
+func processTargetGroups(processes []ccv3.Process, hosts map[string]string, states instanceStates, exporter Exporter, labels map[string]string) []*promconfig.Group {
+	var groups []*promconfig.Group
+
+	for _, p := range processes {
+		if exporter.ProcessType != "" && exporter.ProcessType != p.Type {
+			continue
+		}
+		host, ok := hosts[p.Type]
+		if !ok || !p.Instances.IsSet || p.Instances.Value == 0 {
+			continue
+		}
+		var processGroups []*promconfig.Group
+		groupsByKey := make(map[string]*promconfig.Group)
+		for count := 0; count < p.Instances.Value; count++ {
+			state := ""
+			switch states.mode {
+			case InstanceStatesDrop:
+				if !states.running(p.GUID, count) {
+					continue
+				}
+			case InstanceStatesLabel:
+				state = states.state(p.GUID, count)
+			}
+			key := state
+			if states.index {
+				key = strconv.Itoa(count)
+			}
+			group, ok := groupsByKey[key]
+			if !ok {
+				groupLabels := map[string]string{
+					"cf_process_type": p.Type,
+				}
+				if state != "" {
+					groupLabels["cf_instance_state"] = state
+				}
+				if states.index {
+					groupLabels["cf_instance_index"] = key
+				}
+				for k, v := range labels {
+					groupLabels[k] = v
+				}
+				group = &promconfig.Group{Labels: groupLabels}
+				groupsByKey[key] = group
+				processGroups = append(processGroups, group)
+			}
+			group.Targets = append(group.Targets, fmt.Sprintf("%d.%s:%d", count, host, exporter.Port))
+		}
+		if !states.index {
+			sort.Slice(processGroups, func(i, j int) bool {
+				return processGroups[i].Labels["cf_instance_state"] < processGroups[j].Labels["cf_instance_state"]
+			})
+		}
+		groups = append(groups, processGroups...)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Labels["cf_process_type"] < groups[j].Labels["cf_process_type"]
+	})
+	return groups
+}
+
+// InstanceStatesRetrieve returns the state of each instance of the given processes
+func InstanceStatesRetrieve(session *clients.Session, processes []ccv3.Process) (map[string]map[int]string, error) {
+	states := make(map[string]map[int]string)
+	for _, p := range processes {
+		instances, _, err := session.V3().GetProcessInstances(p.GUID)
+		if err != nil {
+			return states, fmt.Errorf("process stats %s: %w", p.GUID, err)
+		}
+		states[p.GUID] = make(map[int]string)
+		for _, i := range instances {
+			states[p.GUID][int(i.Index)] = string(i.State)
+		}
+	}
+	return states, nil
+}
@@ -0,0 +1,85 @@
+package tva
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3"
+	"code.cloudfoundry.org/cli/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessTargetGroups(t *testing.T) {
+	processes := []ccv3.Process{
+		{GUID: "worker-guid", Type: "worker", Instances: types.NullInt{IsSet: true, Value: 3}},
+		{GUID: "web-guid", Type: "web", Instances: types.NullInt{IsSet: true, Value: 2}},
+		{Type: "scheduler", Instances: types.NullInt{IsSet: true, Value: 1}},
+	}
+	hosts := map[string]string{
+		"web":    "app.apps.internal",
+		"worker": "app-worker.apps.internal",
+	}
+	labels := map[string]string{"cf_app_name": "app"}
+
+	groups := processTargetGroups(processes, hosts, instanceStates{}, Exporter{Port: 9090}, labels)
+	if !assert.Len(t, groups, 2) {
+		return
+	}
+	assert.Equal(t, []string{"0.app.apps.internal:9090", "1.app.apps.internal:9090"}, groups[0].Targets)
+	assert.Equal(t, "web", groups[0].Labels["cf_process_type"])
+	assert.Equal(t, "app", groups[0].Labels["cf_app_name"])
+	assert.Len(t, groups[1].Targets, 3)
+	assert.Equal(t, "worker", groups[1].Labels["cf_process_type"])
+
+	groups = processTargetGroups(processes, hosts, instanceStates{}, Exporter{Port: 9100, ProcessType: "worker"}, labels)
+	if !assert.Len(t, groups, 1) {
+		return
+	}
+	assert.Equal(t, "2.app-worker.apps.internal:9100", groups[0].Targets[2])
+	_, ok := labels["cf_process_type"]
+	assert.False(t, ok)
+
+	assert.Equal(t, "app.apps.internal", primaryHost(hosts))
+	assert.Equal(t, "app-worker.apps.internal", primaryHost(map[string]string{"worker": "app-worker.apps.internal"}))
+}
+
+func TestProcessTargetGroupsInstanceStates(t *testing.T) {
+	processes := []ccv3.Process{
+		{GUID: "web-guid", Type: "web", Instances: types.NullInt{IsSet: true, Value: 3}},
+	}
+	hosts := map[string]string{
+		"web": "app.apps.internal",
+	}
+	states := map[string]map[int]string{
+		"web-guid": {0: "RUNNING", 1: "CRASHED"},
+	}
+
+	drop := instanceStates{mode: InstanceStatesDrop, states: states}
+	groups := processTargetGroups(processes, hosts, drop, Exporter{Port: 9090}, nil)
+	if !assert.Len(t, groups, 1) {
+		return
+	}
+	assert.Equal(t, []string{"0.app.apps.internal:9090"}, groups[0].Targets)
+	assert.Equal(t, 2, drop.excluded(processes, hosts))
+
+	label := instanceStates{mode: InstanceStatesLabel, states: states}
+	groups = processTargetGroups(processes, hosts, label, Exporter{Port: 9090}, nil)
+	if !assert.Len(t, groups, 3) {
+		return
+	}
+	assert.Equal(t, "CRASHED", groups[0].Labels["cf_instance_state"])
+	assert.Equal(t, []string{"1.app.apps.internal:9090"}, groups[0].Targets)
+	assert.Equal(t, "RUNNING", groups[1].Labels["cf_instance_state"])
+	assert.Equal(t, "UNKNOWN", groups[2].Labels["cf_instance_state"])
+	assert.Equal(t, 0, label.excluded(processes, hosts))
+
+	index := instanceStates{mode: InstanceStatesDrop, states: states, index: true}
+	processes[0].Instances.Value = 12
+	states["web-guid"][10] = "RUNNING"
+	groups = processTargetGroups(processes, hosts, index, Exporter{Port: 9090}, nil)
+	if !assert.Len(t, groups, 2) {
+		return
+	}
+	assert.Equal(t, "0", groups[0].Labels["cf_instance_index"])
+	assert.Equal(t, "10", groups[1].Labels["cf_instance_index"])
+	assert.Equal(t, []string{"10.app.apps.internal:9090"}, groups[1].Targets)
+}
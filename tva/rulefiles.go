@@ -0,0 +1,81 @@
+package tva
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// ruleFilesManifest lists the rule files variant wrote, so it knows which files to
+// remove once their apps disappear without touching any other files in the folder
+const ruleFilesManifest = ".variant-rules.json"
+
+// ruleFileRE matches the <app-guid>.yml rule files written before the manifest existed
+var ruleFileRE = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.yml$`)
+
+// rulesFolder returns the folder rule files are written to. Relative folders are
+// resolved against the folder of the Prometheus config, just like Prometheus does
+// when it reads rule_files.
+func (t *Timeline) rulesFolder() string {
+	if path.IsAbs(t.rulesDir) {
+		return t.rulesDir
+	}
+	return path.Join(path.Dir(t.config.PrometheusConfig), t.rulesDir)
+}
+
+// ruleFilePaths returns the path variant writes a rule file to and the path
+// to reference from prometheus.yml
+func (t *Timeline) ruleFilePaths(name string) (string, string) {
+	return path.Join(t.rulesFolder(), name), path.Join(t.rulesDir, name)
+}
+
+func (t *Timeline) ruleFilesManifest() manifest {
+	return manifest{
+		dir:    t.rulesFolder(),
+		name:   ruleFilesManifest,
+		legacy: ruleFileRE.MatchString,
+	}
+}
+
+// trackRuleFiles adds rule files to the manifest before they are written,
+// so they are cleaned up even when variant stops halfway
+func (t *Timeline) trackRuleFiles(names ...string) error {
+	return t.ruleFilesManifest().track(names...)
+}
+
+// ruleFilesChanged reports whether writing the rendered rule files would change any of them
+func (t *Timeline) ruleFilesChanged(outputs map[string][]byte) bool {
+	for n, output := range outputs {
+		ruleFile, _ := t.ruleFilePaths(n)
+		data, err := os.ReadFile(ruleFile)
+		if err != nil || !bytes.Equal(data, output) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneRuleFiles removes the rule files of apps which no longer have rules, once
+// the config which references the remaining ones is in place
+func (t *Timeline) pruneRuleFiles(files ruleFiles) error {
+	keep := make(map[string]bool)
+	for n := range files {
+		keep[n] = true
+	}
+	if err := t.ruleFilesManifest().prune(keep, t.debug); err != nil {
+		return fmt.Errorf("rule files: %w", err)
+	}
+	return nil
+}
+
+// managedRuleFile reports whether a rule file referenced from prometheus.yml is
+// written by variant, or sits next to the config, and returns its path on disk
+func (t *Timeline) managedRuleFile(ruleFile string) (string, bool) {
+	if !filepath.IsAbs(ruleFile) {
+		return filepath.Join(path.Dir(t.config.PrometheusConfig), ruleFile), true
+	}
+	return ruleFile, filepath.Dir(ruleFile) == filepath.Clean(t.rulesFolder())
+}
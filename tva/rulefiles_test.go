@@ -0,0 +1,86 @@
+package tva_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRulesDir(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	original, err := os.ReadFile(prometheusConfig)
+	if !assert.Nil(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	if !assert.Nil(t, os.WriteFile(configFile, original, 0644)) {
+		return
+	}
+	rulesFolder := filepath.Join(dir, "rules")
+	if !assert.Nil(t, os.MkdirAll(rulesFolder, 0755)) {
+		return
+	}
+	// A rule file of an app which lost its rules and one which is not ours
+	stale := filepath.Join(rulesFolder, "00000000-0000-0000-0000-000000000000.yml")
+	other := filepath.Join(rulesFolder, "alerts.yml")
+	_ = os.WriteFile(stale, []byte("groups: []\n"), 0644)
+	_ = os.WriteFile(other, []byte("groups: []\n"), 0644)
+
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}, tva.WithTenants("default"), tva.WithReload(false), tva.WithRulesDir("rules"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	var cfg tva.PrometheusConfig
+	if !assert.Nil(t, yaml.Unmarshal([]byte(output), &cfg)) {
+		return
+	}
+	assert.Contains(t, cfg.RuleFiles, "rules/9e22fe38-38ce-4af6-b529-44d2853d072f.yml")
+	assert.FileExists(t, filepath.Join(rulesFolder, "9e22fe38-38ce-4af6-b529-44d2853d072f.yml"))
+	assert.NoFileExists(t, filepath.Join(dir, "9e22fe38-38ce-4af6-b529-44d2853d072f.yml"))
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, other)
+
+	data, err := os.ReadFile(filepath.Join(rulesFolder, ".variant-rules.json"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	var owned []string
+	if assert.Nil(t, json.Unmarshal(data, &owned)) {
+		assert.Equal(t, []string{"9e22fe38-38ce-4af6-b529-44d2853d072f.yml"}, owned)
+	}
+
+	// Files listed in the manifest are removed once their app has no rules
+	owned = append(owned, "gone.yml")
+	data, _ = json.Marshal(owned)
+	_ = os.WriteFile(filepath.Join(rulesFolder, ".variant-rules.json"), data, 0644)
+	_ = os.WriteFile(filepath.Join(rulesFolder, "gone.yml"), []byte("groups: []\n"), 0644)
+	_, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NoFileExists(t, filepath.Join(rulesFolder, "gone.yml"))
+	assert.FileExists(t, other)
+}
@@ -0,0 +1,113 @@
+package tva
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/percona/promconfig"
+	"github.com/percona/promconfig/rules"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v2"
+)
+
+// ValidateRules checks the rules of an app the way Prometheus does when loading them:
+// PromQL expressions, for durations, label and annotation names and templates
+func ValidateRules(entries []rules.RuleNode) error {
+	content, err := yaml.Marshal(rules.RuleGroups{
+		Groups: []rules.RuleGroup{
+			{
+				Name:  "VariantGroup",
+				Rules: entries,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal rules: %w", err)
+	}
+	_, errs := rulefmt.Parse(content)
+	return errors.Join(errs...)
+}
+
+// QuarantinedRules records the rules of an app which were left out because they are invalid
+type QuarantinedRules struct {
+	AppGUID string `json:"app_guid"`
+	AppName string `json:"app_name"`
+	Error   string `json:"error"`
+}
+
+// ValidateConfig checks the generated config for problems which make Prometheus reject it:
+// missing or duplicate job names and scrape configs ValidateScrapeConfig refuses
+func ValidateConfig(cfg PrometheusConfig) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, sc := range cfg.ScrapeConfigs {
+		switch {
+		case sc.JobName == "":
+			errs = append(errs, fmt.Errorf("scrape config without job_name"))
+		case seen[sc.JobName]:
+			errs = append(errs, fmt.Errorf("duplicate job_name: %s", sc.JobName))
+		default:
+			if err := ValidateScrapeConfig(*sc, cfg.GlobalConfig); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		seen[sc.JobName] = true
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateScrapeConfig checks the settings of a scrape config the way the Prometheus config
+// loader does, applying the global scrape_interval to jobs which do not set their own
+func ValidateScrapeConfig(sc ScrapeConfig, global promconfig.GlobalConfig) error {
+	var errs []error
+	interval := scrapeInterval(sc, global.ScrapeInterval)
+	if sc.ScrapeTimeout > interval {
+		errs = append(errs, fmt.Errorf("scrape_timeout %s exceeds scrape_interval %s", sc.ScrapeTimeout, interval))
+	}
+	seen := make(map[string]bool)
+	for _, p := range sc.ScrapeProtocols {
+		if !knownScrapeProtocol(p) {
+			errs = append(errs, fmt.Errorf("unknown scrape protocol %s", p))
+		} else if seen[p] {
+			errs = append(errs, fmt.Errorf("duplicate scrape protocol %s", p))
+		}
+		seen[p] = true
+	}
+	if sc.BodySizeLimit != "" {
+		if _, err := ParseBodySize(sc.BodySizeLimit); err != nil {
+			errs = append(errs, fmt.Errorf("body_size_limit: %w", err))
+		}
+	}
+	for field, configs := range map[string][]*promconfig.RelabelConfig{
+		"relabel_configs":        sc.RelabelConfigs,
+		"metric_relabel_configs": sc.MetricRelabelConfigs,
+	} {
+		for i, r := range configs {
+			if r == nil {
+				continue
+			}
+			if err := FromProm(r).Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s %d: %w", field, i, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("job %s: %w", sc.JobName, err)
+	}
+	return nil
+}
+
+// validScrapeConfigs leaves out all scrape configs of an app when any of them would make
+// Prometheus reject the config, so one app cannot block config updates for everyone
+func validScrapeConfigs(configs []ScrapeConfig, global promconfig.GlobalConfig) ([]ScrapeConfig, error) {
+	var errs []error
+	for _, sc := range configs {
+		if err := ValidateScrapeConfig(sc, global); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, invalidScrapeSettings(errs...)
+	}
+	return configs, nil
+}
@@ -0,0 +1,161 @@
+package tva_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/percona/promconfig"
+	"github.com/percona/promconfig/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRules(t *testing.T) {
+	valid := rules.RuleNode{
+		Alert:       "KongWaitingConnections",
+		Expr:        `kong_nginx_http_current_connections{state="waiting"} > 2`,
+		For:         "1m",
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Instance {{ $labels.instance }} has waiting connections"},
+	}
+	assert.Nil(t, tva.ValidateRules([]rules.RuleNode{valid}))
+
+	invalidExpr := valid
+	invalidExpr.Expr = "rate(kong_nginx_http_current_connections[5m]"
+	assert.NotNil(t, tva.ValidateRules([]rules.RuleNode{valid, invalidExpr}))
+
+	invalidFor := valid
+	invalidFor.For = "a minute"
+	assert.NotNil(t, tva.ValidateRules([]rules.RuleNode{invalidFor}))
+
+	invalidLabel := valid
+	invalidLabel.Labels = map[string]string{"team-name": "ops"}
+	assert.NotNil(t, tva.ValidateRules([]rules.RuleNode{invalidLabel}))
+
+	invalidTemplate := valid
+	invalidTemplate.Annotations = map[string]string{"summary": "Instance {{ $labels.instance }"}
+	assert.NotNil(t, tva.ValidateRules([]rules.RuleNode{invalidTemplate}))
+}
+
+func TestValidateConfig(t *testing.T) {
+	job := func(name string) *tva.ScrapeConfig {
+		return &tva.ScrapeConfig{ScrapeConfig: promconfig.ScrapeConfig{JobName: name}}
+	}
+	assert.Nil(t, tva.ValidateConfig(tva.PrometheusConfig{
+		ScrapeConfigs: []*tva.ScrapeConfig{job("a"), job("b")},
+	}))
+	assert.NotNil(t, tva.ValidateConfig(tva.PrometheusConfig{
+		ScrapeConfigs: []*tva.ScrapeConfig{job("a"), job("a")},
+	}))
+	assert.NotNil(t, tva.ValidateConfig(tva.PrometheusConfig{
+		ScrapeConfigs: []*tva.ScrapeConfig{job("")},
+	}))
+
+	// The timeout of a job without interval may not exceed the global interval
+	global := promconfig.GlobalConfig{ScrapeInterval: promconfig.Duration(10 * time.Second)}
+	slow := job("slow")
+	slow.ScrapeTimeout = promconfig.Duration(30 * time.Second)
+	err := tva.ValidateConfig(tva.PrometheusConfig{
+		GlobalConfig:  global,
+		ScrapeConfigs: []*tva.ScrapeConfig{job("a"), slow},
+	})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "slow")
+	}
+	slow.ScrapeInterval = promconfig.Duration(time.Minute)
+	assert.Nil(t, tva.ValidateConfig(tva.PrometheusConfig{
+		GlobalConfig:  global,
+		ScrapeConfigs: []*tva.ScrapeConfig{job("a"), slow},
+	}))
+
+	// The global interval defaults to a minute
+	assert.Nil(t, tva.ValidateConfig(tva.PrometheusConfig{
+		ScrapeConfigs: []*tva.ScrapeConfig{job("a"), slow},
+	}))
+}
+
+func TestValidateScrapeConfig(t *testing.T) {
+	var global promconfig.GlobalConfig
+	sc := tva.ScrapeConfig{ScrapeConfig: promconfig.ScrapeConfig{JobName: "ceres"}}
+	sc.ScrapeTimeout = promconfig.Duration(30 * time.Second)
+	sc.ScrapeProtocols = []string{"PrometheusProto", "PrometheusText0.0.4"}
+	sc.RelabelConfigs = []*promconfig.RelabelConfig{
+		{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+		{TargetLabel: "cluster_${1}", Replacement: "belt"},
+	}
+	assert.Nil(t, tva.ValidateScrapeConfig(sc, global))
+
+	timeout := sc
+	timeout.ScrapeTimeout = promconfig.Duration(2 * time.Minute)
+	assert.NotNil(t, tva.ValidateScrapeConfig(timeout, global))
+
+	protocols := sc
+	protocols.ScrapeProtocols = []string{"PrometheusText1.0.0"}
+	assert.NotNil(t, tva.ValidateScrapeConfig(protocols, global))
+	protocols.ScrapeProtocols = []string{"PrometheusProto", "PrometheusProto"}
+	assert.NotNil(t, tva.ValidateScrapeConfig(protocols, global))
+
+	relabel := sc
+	relabel.MetricRelabelConfigs = []*promconfig.RelabelConfig{{TargetLabel: "foo-bar", Action: "replace"}}
+	err := tva.ValidateScrapeConfig(relabel, global)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "ceres")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	original, err := os.ReadFile(prometheusConfig)
+	if !assert.Nil(t, err) {
+		return
+	}
+	configFile := filepath.Join(t.TempDir(), "prometheus.yml")
+	if !assert.Nil(t, os.WriteFile(configFile, original, 0644)) {
+		return
+	}
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	handler := timeline.StatusHandler()
+	req := httptest.NewRequest(http.MethodPost, "/status", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if !assert.Equal(t, http.StatusOK, resp.Code) {
+		return
+	}
+	var status tva.Status
+	if assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status)) {
+		assert.Equal(t, uint64(1), status.Reconciles)
+		assert.False(t, status.Paused)
+		assert.Len(t, status.QuarantinedRules, 0)
+	}
+}
@@ -0,0 +1,296 @@
+package tva
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/promconfig"
+)
+
+// PrometheusConfig mirrors promconfig.Config using our extended ScrapeConfig
+type PrometheusConfig struct {
+	GlobalConfig       promconfig.GlobalConfig         `yaml:"global"`
+	AlertingConfig     promconfig.AlertingConfig       `yaml:"alerting,omitempty"`
+	RuleFiles          []string                        `yaml:"rule_files,omitempty"`
+	ScrapeConfigs      []*ScrapeConfig                 `yaml:"scrape_configs,omitempty"`
+	RemoteWriteConfigs []*promconfig.RemoteWriteConfig `yaml:"remote_write,omitempty"`
+	RemoteReadConfigs  []*promconfig.RemoteReadConfig  `yaml:"remote_read,omitempty"`
+}
+
+// ScrapeConfig extends promconfig.ScrapeConfig with settings it does not support yet
+type ScrapeConfig struct {
+	promconfig.ScrapeConfig `yaml:",inline"`
+
+	// More than this many labels post metric-relabeling will cause the scrape to fail.
+	LabelLimit uint `yaml:"label_limit,omitempty"`
+	// More than this many targets after the target relabeling will cause the scrapes to fail.
+	TargetLimit uint `yaml:"target_limit,omitempty"`
+	// An uncompressed response body larger than this many bytes will cause the scrape to fail.
+	BodySizeLimit string `yaml:"body_size_limit,omitempty"`
+	// The protocols to negotiate during a scrape, in order of preference.
+	ScrapeProtocols []string `yaml:"scrape_protocols,omitempty"`
+	// Custom HTTP headers to be sent along with each scrape request.
+	HTTPHeaders map[string]HTTPHeader `yaml:"http_headers,omitempty"`
+}
+
+// HTTPHeader holds the values of a custom scrape request header
+type HTTPHeader struct {
+	Values []string `yaml:"values,omitempty"`
+}
+
+// Discoverable reports whether the targets of the scrape config can be served
+// through service discovery. Request headers, relabel configs, honor_labels and
+// client settings like TLS and credentials are part of the scrape config itself,
+// so they cannot be passed along. Only the client settings every job gets, the
+// defaults, are expected on the job consuming the targets.
+func (cfg ScrapeConfig) Discoverable(defaults promconfig.HTTPClientConfig) bool {
+	if len(cfg.HTTPHeaders) > 0 || len(cfg.Params) > 0 || cfg.HonorLabels {
+		return false
+	}
+	if len(cfg.RelabelConfigs) > 0 || len(cfg.MetricRelabelConfigs) > 0 {
+		return false
+	}
+	return reflect.DeepEqual(cfg.HTTPClientConfig, defaults)
+}
+
+// ScrapeTuning holds the scrape settings apps can tune through annotations
+type ScrapeTuning struct {
+	ScrapeTimeout   string   `json:"scrape_timeout,omitempty"`
+	HonorLabels     *bool    `json:"honor_labels,omitempty"`
+	SampleLimit     *uint    `json:"sample_limit,omitempty"`
+	LabelLimit      *uint    `json:"label_limit,omitempty"`
+	TargetLimit     *uint    `json:"target_limit,omitempty"`
+	BodySizeLimit   string   `json:"body_size_limit,omitempty"`
+	ScrapeProtocols []string `json:"scrape_protocols,omitempty"`
+}
+
+// ScrapeLimits are the operator defined ceilings of the limits apps can set.
+// Apps which set no limit, or 0, get the ceiling. A zero ceiling means unlimited.
+type ScrapeLimits struct {
+	SampleLimit   uint
+	LabelLimit    uint
+	TargetLimit   uint
+	BodySizeLimit string
+}
+
+// defaultGlobalScrapeInterval is the scrape_interval Prometheus uses when the global section sets none
+const defaultGlobalScrapeInterval = promconfig.Duration(time.Minute)
+
+var (
+	// ErrInvalidScrapeSettings is returned when an app has scrape settings which were not applied
+	ErrInvalidScrapeSettings = errors.New("invalid scrape settings")
+
+	bodySizeRE = regexp.MustCompile(`^([0-9]+)(B|KB|MB|GB|TB|PB|EB)$`)
+
+	scrapeProtocols = []string{
+		"PrometheusProto",
+		"OpenMetricsText0.0.1",
+		"OpenMetricsText1.0.0",
+		"PrometheusText0.0.4",
+	}
+)
+
+// ParseBodySize returns the number of bytes of a size using base 2 units e.g. 10MB
+func ParseBodySize(size string) (uint64, error) {
+	matches := bodySizeRE.FindStringSubmatch(size)
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("not a valid size: %q", size)
+	}
+	n, err := strconv.ParseUint(matches[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	for _, unit := range []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"} {
+		if unit == matches[2] {
+			return n, nil
+		}
+		n *= 1024
+	}
+	return n, nil
+}
+
+// invalidScrapeSettings wraps the errors of invalid settings in ErrInvalidScrapeSettings,
+// flattening errors which were wrapped before. It returns nil when there are none.
+func invalidScrapeSettings(errs ...error) error {
+	var flat []error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if wrapped, ok := err.(interface{ Unwrap() []error }); ok && errors.Is(err, ErrInvalidScrapeSettings) {
+			for _, e := range wrapped.Unwrap() {
+				if e != ErrInvalidScrapeSettings {
+					flat = append(flat, e)
+				}
+			}
+			continue
+		}
+		flat = append(flat, err)
+	}
+	if len(flat) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidScrapeSettings, errors.Join(flat...))
+}
+
+// ParseScrapeTuning reads the scrape tuning annotations of an app. Invalid values
+// are skipped and returned as error.
+func ParseScrapeTuning(metadata Metadata) (ScrapeTuning, error) {
+	var tuning ScrapeTuning
+	var invalid []error
+	if timeout := metadata.Annotations[AnnotationExporterScrapeTimeout]; timeout != nil {
+		tuning.ScrapeTimeout = *timeout
+	}
+	if honorLabels := metadata.Annotations[AnnotationExporterHonorLabels]; honorLabels != nil {
+		honor, err := strconv.ParseBool(*honorLabels)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("%s: %w", AnnotationExporterHonorLabels, err))
+		} else {
+			tuning.HonorLabels = &honor
+		}
+	}
+	for annotation, field := range map[string]**uint{
+		AnnotationExporterSampleLimit: &tuning.SampleLimit,
+		AnnotationExporterLabelLimit:  &tuning.LabelLimit,
+		AnnotationExporterTargetLimit: &tuning.TargetLimit,
+	} {
+		if value := metadata.Annotations[annotation]; value != nil {
+			n, err := strconv.ParseUint(*value, 10, 32)
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("%s: %w", annotation, err))
+				continue
+			}
+			limit := uint(n)
+			*field = &limit
+		}
+	}
+	if bodySizeLimit := metadata.Annotations[AnnotationExporterBodySizeLimit]; bodySizeLimit != nil {
+		tuning.BodySizeLimit = *bodySizeLimit
+	}
+	if protocols := metadata.Annotations[AnnotationExporterProtocols]; protocols != nil {
+		for _, p := range strings.Split(*protocols, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				tuning.ScrapeProtocols = append(tuning.ScrapeProtocols, p)
+			}
+		}
+	}
+	return tuning, errors.Join(invalid...)
+}
+
+// knownScrapeProtocol is case-sensitive, just like Prometheus
+func knownScrapeProtocol(protocol string) bool {
+	for _, p := range scrapeProtocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// limit returns the limit to use for the value requested by an app
+func limit(name string, requested *uint, ceiling uint) (uint, error) {
+	if requested == nil || *requested == 0 {
+		return ceiling, nil
+	}
+	if ceiling > 0 && *requested > ceiling {
+		return ceiling, fmt.Errorf("%s %d exceeds maximum of %d", name, *requested, ceiling)
+	}
+	return *requested, nil
+}
+
+// scrapeInterval returns the interval Prometheus scrapes the job at: its own
+// scrape_interval, else the global one, else the Prometheus default
+func scrapeInterval(cfg ScrapeConfig, global promconfig.Duration) promconfig.Duration {
+	if cfg.ScrapeInterval != 0 {
+		return cfg.ScrapeInterval
+	}
+	if global != 0 {
+		return global
+	}
+	return defaultGlobalScrapeInterval
+}
+
+// Apply sets the tuned settings on the scrape config. Invalid values are
+// skipped and reported in the returned error, limits are capped to the ceilings.
+// The global interval is the scrape_interval of the global section of the
+// Prometheus config, which applies when the job sets none.
+func (s ScrapeTuning) Apply(cfg *ScrapeConfig, limits ScrapeLimits, globalInterval promconfig.Duration) error {
+	var invalid []error
+
+	if s.ScrapeTimeout != "" {
+		var timeout promconfig.Duration
+		interval := scrapeInterval(*cfg, globalInterval)
+		if err := timeout.Set(s.ScrapeTimeout); err != nil {
+			invalid = append(invalid, fmt.Errorf("scrape_timeout: %w", err))
+		} else if timeout > interval {
+			invalid = append(invalid, fmt.Errorf("scrape_timeout %s exceeds scrape_interval %s", timeout, interval))
+		} else {
+			cfg.ScrapeTimeout = timeout
+		}
+	}
+	if s.HonorLabels != nil {
+		cfg.HonorLabels = *s.HonorLabels
+	}
+
+	var err error
+	if cfg.SampleLimit, err = limit("sample_limit", s.SampleLimit, limits.SampleLimit); err != nil {
+		invalid = append(invalid, err)
+	}
+	if cfg.LabelLimit, err = limit("label_limit", s.LabelLimit, limits.LabelLimit); err != nil {
+		invalid = append(invalid, err)
+	}
+	if cfg.TargetLimit, err = limit("target_limit", s.TargetLimit, limits.TargetLimit); err != nil {
+		invalid = append(invalid, err)
+	}
+
+	cfg.BodySizeLimit = limits.BodySizeLimit
+	if s.BodySizeLimit != "" {
+		if err := s.applyBodySizeLimit(cfg, limits.BodySizeLimit); err != nil {
+			invalid = append(invalid, err)
+		}
+	}
+
+	for _, p := range s.ScrapeProtocols {
+		if !knownScrapeProtocol(p) {
+			invalid = append(invalid, fmt.Errorf("unknown scrape protocol %s", p))
+			continue
+		}
+		cfg.ScrapeProtocols = append(cfg.ScrapeProtocols, p)
+	}
+	return errors.Join(invalid...)
+}
+
+func (s ScrapeTuning) applyBodySizeLimit(cfg *ScrapeConfig, ceiling string) error {
+	requested, err := ParseBodySize(s.BodySizeLimit)
+	if err != nil {
+		return fmt.Errorf("body_size_limit: %w", err)
+	}
+	if requested == 0 {
+		return nil // Keep the ceiling
+	}
+	if ceiling != "" {
+		maximum, err := ParseBodySize(ceiling)
+		if err != nil {
+			return fmt.Errorf("body_size_limit ceiling: %w", err)
+		}
+		if maximum > 0 && requested > maximum {
+			return fmt.Errorf("body_size_limit %s exceeds maximum of %s", s.BodySizeLimit, ceiling)
+		}
+	}
+	cfg.BodySizeLimit = s.BodySizeLimit
+	return nil
+}
+
+// Validate checks the ceilings themselves
+func (l ScrapeLimits) Validate() error {
+	if l.BodySizeLimit == "" {
+		return nil
+	}
+	_, err := ParseBodySize(l.BodySizeLimit)
+	return err
+}
@@ -0,0 +1,160 @@
+package tva_test
+
+import (
+	"testing"
+	"time"
+	"variant/tva"
+
+	"github.com/percona/promconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBodySize(t *testing.T) {
+	size, err := tva.ParseBodySize("10MB")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, uint64(10*1024*1024), size)
+
+	size, err = tva.ParseBodySize("512B")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, uint64(512), size)
+
+	_, err = tva.ParseBodySize("10mb")
+	assert.NotNil(t, err)
+	_, err = tva.ParseBodySize("MB")
+	assert.NotNil(t, err)
+}
+
+func TestParseScrapeTuning(t *testing.T) {
+	timeout := "10s"
+	honor := "true"
+	sampleLimit := "5000"
+	protocols := "PrometheusProto, OpenMetricsText1.0.0"
+
+	tuning, err := tva.ParseScrapeTuning(tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationExporterScrapeTimeout: &timeout,
+		tva.AnnotationExporterHonorLabels:   &honor,
+		tva.AnnotationExporterSampleLimit:   &sampleLimit,
+		tva.AnnotationExporterProtocols:     &protocols,
+	}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "10s", tuning.ScrapeTimeout)
+	if assert.NotNil(t, tuning.HonorLabels) {
+		assert.True(t, *tuning.HonorLabels)
+	}
+	if assert.NotNil(t, tuning.SampleLimit) {
+		assert.Equal(t, uint(5000), *tuning.SampleLimit)
+	}
+	assert.Nil(t, tuning.LabelLimit)
+	assert.Equal(t, []string{"PrometheusProto", "OpenMetricsText1.0.0"}, tuning.ScrapeProtocols)
+
+	invalid := "-1"
+	_, err = tva.ParseScrapeTuning(tva.Metadata{Annotations: map[string]*string{
+		tva.AnnotationExporterTargetLimit: &invalid,
+	}})
+	assert.NotNil(t, err)
+}
+
+func TestScrapeTuningApply(t *testing.T) {
+	limits := tva.ScrapeLimits{
+		SampleLimit:   10000,
+		BodySizeLimit: "10MB",
+	}
+	sampleLimit := uint(5000)
+	labelLimit := uint(30)
+	honor := true
+
+	var cfg tva.ScrapeConfig
+	cfg.ScrapeInterval = promconfig.Duration(30 * time.Second)
+	err := tva.ScrapeTuning{
+		ScrapeTimeout:   "10s",
+		HonorLabels:     &honor,
+		SampleLimit:     &sampleLimit,
+		LabelLimit:      &labelLimit,
+		BodySizeLimit:   "1MB",
+		ScrapeProtocols: []string{"PrometheusProto"},
+	}.Apply(&cfg, limits, 0)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, promconfig.Duration(10*time.Second), cfg.ScrapeTimeout)
+	assert.True(t, cfg.HonorLabels)
+	assert.Equal(t, uint(5000), cfg.SampleLimit)
+	assert.Equal(t, uint(30), cfg.LabelLimit)
+	assert.Equal(t, uint(0), cfg.TargetLimit)
+	assert.Equal(t, "1MB", cfg.BodySizeLimit)
+	assert.Equal(t, []string{"PrometheusProto"}, cfg.ScrapeProtocols)
+
+	// Apps cannot disable or exceed the ceilings
+	disabled := uint(0)
+	tooMany := uint(20000)
+	cfg = tva.ScrapeConfig{}
+	cfg.ScrapeInterval = promconfig.Duration(30 * time.Second)
+	err = tva.ScrapeTuning{
+		ScrapeTimeout:   "1m",
+		SampleLimit:     &disabled,
+		BodySizeLimit:   "1GB",
+		ScrapeProtocols: []string{"prometheusproto"},
+	}.Apply(&cfg, limits, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, promconfig.Duration(0), cfg.ScrapeTimeout)
+	assert.Equal(t, uint(10000), cfg.SampleLimit)
+	assert.Equal(t, "10MB", cfg.BodySizeLimit)
+	assert.Len(t, cfg.ScrapeProtocols, 0)
+
+	cfg = tva.ScrapeConfig{}
+	err = tva.ScrapeTuning{SampleLimit: &tooMany}.Apply(&cfg, limits, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, uint(10000), cfg.SampleLimit)
+
+	// Without an interval of its own the timeout is compared with the global interval
+	cfg = tva.ScrapeConfig{}
+	err = tva.ScrapeTuning{ScrapeTimeout: "20s"}.Apply(&cfg, limits, promconfig.Duration(15*time.Second))
+	assert.NotNil(t, err)
+	assert.Equal(t, promconfig.Duration(0), cfg.ScrapeTimeout)
+	err = tva.ScrapeTuning{ScrapeTimeout: "20s"}.Apply(&cfg, limits, 0) // Prometheus defaults to a minute
+	assert.Nil(t, err)
+	assert.Equal(t, promconfig.Duration(20*time.Second), cfg.ScrapeTimeout)
+
+	// Prometheus 2.x does not know the PrometheusText1.0.0 protocol yet
+	cfg = tva.ScrapeConfig{}
+	err = tva.ScrapeTuning{ScrapeProtocols: []string{"PrometheusText1.0.0"}}.Apply(&cfg, limits, 0)
+	assert.NotNil(t, err)
+	assert.Len(t, cfg.ScrapeProtocols, 0)
+}
+
+func TestDiscoverable(t *testing.T) {
+	defaults := promconfig.HTTPClientConfig{FollowRedirects: true}
+	newConfig := func() tva.ScrapeConfig {
+		var cfg tva.ScrapeConfig
+		cfg.JobName = "ceres-9e22fe38"
+		cfg.HTTPClientConfig = defaults
+		return cfg
+	}
+	assert.True(t, newConfig().Discoverable(defaults))
+
+	cfg := newConfig()
+	cfg.MetricRelabelConfigs = []*promconfig.RelabelConfig{{TargetLabel: "instance", Replacement: "ceres"}}
+	assert.False(t, cfg.Discoverable(defaults))
+
+	cfg = newConfig()
+	cfg.RelabelConfigs = []*promconfig.RelabelConfig{{TargetLabel: "env", Replacement: "prod"}}
+	assert.False(t, cfg.Discoverable(defaults))
+
+	cfg = newConfig()
+	cfg.HTTPClientConfig.TLSConfig.CAFile = "/etc/ssl/ca.pem"
+	assert.False(t, cfg.Discoverable(defaults))
+
+	cfg = newConfig()
+	cfg.HTTPClientConfig.BasicAuth = &promconfig.BasicAuth{Username: "ron", Password: "swanson"}
+	assert.False(t, cfg.Discoverable(defaults))
+
+	cfg = newConfig()
+	cfg.HonorLabels = true
+	assert.False(t, cfg.Discoverable(defaults))
+}
@@ -0,0 +1,201 @@
+package tva
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/cli/resources"
+	"code.cloudfoundry.org/cli/types"
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/percona/promconfig"
+)
+
+// ServiceTargetsCredential is the credentials key of a user-provided service
+// instance which holds its targets, when no targets annotation is set
+const ServiceTargetsCredential = "prometheus_targets"
+
+type ServiceInstancesResponse struct {
+	Resources []ServiceInstance `json:"resources"`
+}
+
+type ServiceInstance struct {
+	GUID          string   `json:"guid"`
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Metadata      Metadata `json:"metadata"`
+	Relationships struct {
+		Space struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"space"`
+	} `json:"relationships"`
+}
+
+// ServiceInstancesRetrieve returns the service instances matching the label selectors
+func ServiceInstancesRetrieve(client *clients.RawClient, selectors []string) ([]ServiceInstance, error) {
+	var instances ServiceInstancesResponse
+	query := url.Values{}
+	query.Set("label_selector", strings.Join(selectors, ","))
+	query.Set("per_page", "5000")
+	err := RawRetrieve(client, "/v3/service_instances?"+query.Encode(), &instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances.Resources, nil
+}
+
+// Origin returns the service instance in the shape of an app, so it goes
+// through the same tenant and space handling
+func (s ServiceInstance) Origin(org resources.Organization, space resources.Space) App {
+	labels := make(map[string]types.NullString)
+	for k, v := range s.Metadata.Labels {
+		if v != nil {
+			labels[k] = types.NewNullString(*v)
+		}
+	}
+	return App{
+		Application: resources.Application{
+			GUID:      s.GUID,
+			Name:      s.Name,
+			SpaceGUID: s.Relationships.Space.Data.GUID,
+			Metadata:  &resources.Metadata{Labels: labels},
+		},
+		SpaceName:   space.Name,
+		SpaceLabels: resourceLabels(space.Metadata),
+		OrgName:     org.Name,
+		OrgGUID:     org.GUID,
+		OrgLabels:   resourceLabels(org.Metadata),
+	}
+}
+
+// ServiceTargets returns the targets of a service instance from its targets
+// annotation or, for user-provided service instances, from its credentials
+func ServiceTargets(client *clients.RawClient, instance ServiceInstance) ([]string, error) {
+	if targets := instance.Metadata.Annotations[AnnotationServiceTargets]; targets != nil {
+		return splitList(*targets), nil
+	}
+	if instance.Type != string(resources.UserProvidedServiceInstance) {
+		return nil, fmt.Errorf("missing %s annotation", AnnotationServiceTargets)
+	}
+	var credentials map[string]interface{}
+	err := RawRetrieve(client, fmt.Sprintf("/v3/service_instances/%s/credentials", instance.GUID), &credentials)
+	if err != nil {
+		return nil, fmt.Errorf("service credentials: %w", err)
+	}
+	switch targets := credentials[ServiceTargetsCredential].(type) {
+	case string:
+		return splitList(targets), nil
+	case []interface{}:
+		var result []string
+		for _, t := range targets {
+			if target, ok := t.(string); ok && target != "" {
+				result = append(result, target)
+			}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("missing %s annotation or %s credential", AnnotationServiceTargets, ServiceTargetsCredential)
+}
+
+// ServiceTargetLabels returns the labels which are added to all targets of a service instance
+func ServiceTargetLabels(config Config, origin App, labels map[string]*string) map[string]string {
+	targetLabels := TargetLabels(config, origin, labels)
+	delete(targetLabels, "cf_app_name")
+	targetLabels["cf_service_instance_name"] = origin.Name
+	if guid, ok := targetLabels["cf_app_guid"]; ok {
+		delete(targetLabels, "cf_app_guid")
+		targetLabels["cf_service_instance_guid"] = guid
+	}
+	return targetLabels
+}
+
+// GenerateServiceScrapeConfig returns the scrape config of the external endpoints of a service
+// instance. The exporter annotations of apps apply, except for those related to CF processes.
+// Invalid scrape settings are skipped, the config is still returned together with an
+// ErrInvalidScrapeSettings error.
+func GenerateServiceScrapeConfig(session *clients.Session, config Config, instance ServiceInstance, origin App) (*ScrapeConfig, error) {
+	exporters, parseErr := ParseExporters(instance.Metadata)
+	if len(exporters) == 0 {
+		return nil, parseErr
+	}
+	exporter := exporters[0] // Only the single endpoint annotations apply
+	targets, err := ServiceTargets(session.Raw(), instance)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets found")
+	}
+	name := JobName(config, origin, instance.Metadata, "")
+	groups := []*promconfig.Group{
+		{
+			Targets: targets,
+			Labels:  ServiceTargetLabels(config, origin, instance.Metadata.Labels),
+		},
+	}
+	scrapeConfig, invalid := newScrapeConfig(config, name, exporter, exporter.Scheme, groups)
+	if err := applyServiceCredential(config, &scrapeConfig, exporter.Credentials, origin, targets); err != nil {
+		invalid = errors.Join(invalid, err)
+	}
+	if err := appendRelabelConfigs(&scrapeConfig, exporter); err != nil {
+		invalid = errors.Join(invalid, fmt.Errorf("%s: %w", name, err))
+	}
+	return &scrapeConfig, invalidScrapeSettings(parseErr, invalid)
+}
+
+// applyServiceCredential sets the named credential, or the default credential of the space or org, on
+// the scrape config of a service instance. As its targets are chosen by the tenant, variant's own
+// basic auth credentials are never used and a credential only applies when the operator allowed it
+// for all targets through its service_targets.
+func applyServiceCredential(config Config, scrapeConfig *ScrapeConfig, name string, origin App, targets []string) error {
+	credential, err := config.Credentials.Resolve(name, origin)
+	if err != nil {
+		return fmt.Errorf("%s: %w", scrapeConfig.JobName, err)
+	}
+	if credential == nil {
+		return nil
+	}
+	if !credential.AllowsTargets(targets) {
+		if name == "" { // The space or org default only applies to the allowed targets
+			return nil
+		}
+		return fmt.Errorf("%s: credential %s is not allowed for targets %s", scrapeConfig.JobName, name, strings.Join(targets, ", "))
+	}
+	credential.Apply(&scrapeConfig.HTTPClientConfig)
+	return nil
+}
+
+// serviceInstances returns the service instances to scrape, applying the same
+// tenant and space filtering as for apps
+func (t *Timeline) serviceInstances(session *clients.Session) ([]ServiceInstance, error) {
+	instances, err := ServiceInstancesRetrieve(session.Raw(), t.Selectors)
+	if err != nil {
+		return nil, fmt.Errorf("service instances: %w", err)
+	}
+	if len(t.Selectors) > 1 && t.defaultTenant {
+		defaultInstances, err := ServiceInstancesRetrieve(session.Raw(), []string{
+			t.Selectors[0],
+			fmt.Sprintf("!%s", t.config.prefixes.LabelKey(TenantLabel)),
+		})
+		if err == nil {
+			instances = append(instances, defaultInstances...)
+		}
+	}
+	var result []ServiceInstance
+	seen := make(map[string]bool)
+	for _, instance := range instances {
+		if seen[instance.GUID] {
+			continue
+		}
+		seen[instance.GUID] = true
+		instance.Metadata = t.config.prefixes.Metadata(instance.Metadata)
+		if len(t.spaces) > 0 && !ContainsString(t.spaces, instance.Relationships.Space.Data.GUID) {
+			continue
+		}
+		result = append(result, instance)
+	}
+	return result, nil
+}
@@ -0,0 +1,150 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/resources"
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/percona/promconfig"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateServiceScrapeConfig(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	session, err := clients.NewSession(clients.Config{
+		Endpoint: serverCF.URL,
+		User:     "ron",
+		Password: "swanson",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	team := "data"
+	path := "/stats"
+	instance := tva.ServiceInstance{
+		GUID: "5d3a8c1e-7f0b-4c43-a6a3-2f6d1f0e9b7a",
+		Name: "orders-db",
+		Type: "user-provided",
+		Metadata: tva.Metadata{
+			Labels:      map[string]*string{"team": &team},
+			Annotations: map[string]*string{tva.AnnotationExporterPath: &path},
+		},
+	}
+	org := resources.Organization{GUID: "org-guid", Name: "test-org"}
+	space := resources.Space{Name: "test-space"}
+	origin := instance.Origin(org, space)
+	assert.Equal(t, "default", tva.AppTenant(origin.Application))
+
+	cfg, err := tva.GenerateServiceScrapeConfig(session, tva.Config{
+		LabelSources: []string{tva.LabelSourceApp},
+	}, instance, origin)
+	if !assert.Nil(t, err) || !assert.NotNil(t, cfg) {
+		return
+	}
+	assert.Equal(t, "orders-db-5d3a8c1e", cfg.JobName)
+	assert.Equal(t, "/stats", cfg.MetricsPath)
+	group := cfg.ServiceDiscoveryConfig.StaticConfigs[0]
+	assert.Equal(t, []string{"db-exporter.example.com:9187", "db-exporter.example.com:9188"}, group.Targets)
+	assert.Equal(t, "orders-db", group.Labels["cf_service_instance_name"])
+	assert.Equal(t, "test-space", group.Labels["cf_space_name"])
+	assert.Equal(t, "data", group.Labels["cf_label_team"])
+	_, ok := group.Labels["cf_app_name"]
+	assert.False(t, ok)
+
+	targets := "db.example.com:9187"
+	instance.Type = "managed"
+	instance.Metadata.Annotations[tva.AnnotationServiceTargets] = &targets
+	cfg, err = tva.GenerateServiceScrapeConfig(session, tva.Config{}, instance, origin)
+	if !assert.Nil(t, err) || !assert.NotNil(t, cfg) {
+		return
+	}
+	assert.Equal(t, []string{"db.example.com:9187"}, cfg.ServiceDiscoveryConfig.StaticConfigs[0].Targets)
+
+	delete(instance.Metadata.Annotations, tva.AnnotationServiceTargets)
+	_, err = tva.GenerateServiceScrapeConfig(session, tva.Config{}, instance, origin)
+	assert.NotNil(t, err)
+}
+
+func TestServiceCredentials(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	viper.Set("basic_auth_username", "variant")
+	viper.Set("basic_auth_password", "secret")
+	defer func() {
+		viper.Set("basic_auth_username", "")
+		viper.Set("basic_auth_password", "")
+	}()
+
+	session, err := clients.NewSession(clients.Config{
+		Endpoint: serverCF.URL,
+		User:     "ron",
+		Password: "swanson",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	targets := "db.example.com:9187"
+	instance := tva.ServiceInstance{
+		GUID: "5d3a8c1e-7f0b-4c43-a6a3-2f6d1f0e9b7a",
+		Name: "orders-db",
+		Type: "managed",
+		Metadata: tva.Metadata{
+			Annotations: map[string]*string{tva.AnnotationServiceTargets: &targets},
+		},
+	}
+	origin := instance.Origin(resources.Organization{Name: "test-org"}, resources.Space{Name: "test-space"})
+	config := tva.Config{
+		Credentials: &tva.Credentials{
+			Credentials: map[string]tva.Credential{
+				"databases": {
+					BasicAuth:      &promconfig.BasicAuth{Username: "scraper", PasswordFile: "/secrets/databases"},
+					Spaces:         []string{"*"},
+					ServiceTargets: []string{"*.example.com:9187"},
+				},
+				"apps": {
+					BearerTokenFile: "/secrets/apps",
+					Spaces:          []string{"*"},
+				},
+			},
+		},
+	}
+
+	// Variant's own credentials never go to service instance targets
+	cfg, err := tva.GenerateServiceScrapeConfig(session, config, instance, origin)
+	if !assert.Nil(t, err) || !assert.NotNil(t, cfg) {
+		return
+	}
+	assert.Nil(t, cfg.HTTPClientConfig.BasicAuth)
+
+	credential := "databases"
+	instance.Metadata.Annotations[tva.AnnotationExporterCredentials] = &credential
+	cfg, err = tva.GenerateServiceScrapeConfig(session, config, instance, origin)
+	if !assert.Nil(t, err) || !assert.NotNil(t, cfg) {
+		return
+	}
+	if assert.NotNil(t, cfg.HTTPClientConfig.BasicAuth) {
+		assert.Equal(t, "scraper", cfg.HTTPClientConfig.BasicAuth.Username)
+	}
+
+	// Credentials are only sent to the targets the operator allowed
+	credential = "apps"
+	cfg, err = tva.GenerateServiceScrapeConfig(session, config, instance, origin)
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	if assert.NotNil(t, cfg) {
+		assert.Nil(t, cfg.HTTPClientConfig.Authorization)
+		assert.Nil(t, cfg.HTTPClientConfig.BasicAuth)
+	}
+
+	credential = "databases"
+	targets = "db.example.com:9187,collector.attacker.example:9187"
+	cfg, err = tva.GenerateServiceScrapeConfig(session, config, instance, origin)
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	if assert.NotNil(t, cfg) {
+		assert.Nil(t, cfg.HTTPClientConfig.BasicAuth)
+	}
+}
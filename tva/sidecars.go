@@ -0,0 +1,86 @@
+package tva
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3"
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+)
+
+type SidecarsResponse struct {
+	Resources []Sidecar `json:"resources"`
+}
+
+type Sidecar struct {
+	GUID         string   `json:"guid"`
+	Name         string   `json:"name"`
+	ProcessTypes []string `json:"process_types"`
+}
+
+// SidecarsRetrieve returns the sidecars of an app
+func SidecarsRetrieve(client *clients.RawClient, appGUID string) ([]Sidecar, error) {
+	var sidecars SidecarsResponse
+	err := RawRetrieve(client, fmt.Sprintf("/v3/apps/%s/sidecars?per_page=5000", appGUID), &sidecars)
+	if err != nil {
+		return nil, fmt.Errorf("sidecars: %w", err)
+	}
+	return sidecars.Resources, nil
+}
+
+// FindSidecar returns the sidecar with the given name
+func FindSidecar(sidecars []Sidecar, name string) (Sidecar, error) {
+	for _, s := range sidecars {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Sidecar{}, fmt.Errorf("sidecar %s not found", name)
+}
+
+// Processes returns the processes the sidecar runs alongside
+func (s Sidecar) Processes(processes []ccv3.Process) []ccv3.Process {
+	var result []ccv3.Process
+	for _, p := range processes {
+		if ContainsString(s.ProcessTypes, p.Type) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// sidecarExporters returns the exporters which can be scraped, leaving out those of sidecars
+// which do not exist or cannot be reached in the scrape mode, together with the sidecars of the app.
+// When the sidecars cannot be retrieved only the sidecar exporters are left out.
+func sidecarExporters(retrieve func() ([]Sidecar, error), mode string, exporters []Exporter) ([]Exporter, []Sidecar, []error) {
+	var sidecars []Sidecar
+	var invalid []error
+	var result []Exporter
+	var retrieveErr error
+	retrieved := false
+	for _, exporter := range exporters {
+		if exporter.Sidecar == "" {
+			result = append(result, exporter)
+			continue
+		}
+		if mode == ScrapeModePublic {
+			invalid = append(invalid, fmt.Errorf("sidecar %s: not supported in %s scrape mode", exporter.Sidecar, mode))
+			continue
+		}
+		if !retrieved {
+			sidecars, retrieveErr = retrieve()
+			if retrieveErr != nil {
+				invalid = append(invalid, retrieveErr)
+			}
+			retrieved = true
+		}
+		if retrieveErr != nil {
+			continue
+		}
+		if _, err := FindSidecar(sidecars, exporter.Sidecar); err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+		result = append(result, exporter)
+	}
+	return result, sidecars, invalid
+}
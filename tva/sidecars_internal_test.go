@@ -0,0 +1,42 @@
+package tva
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSidecarExporters(t *testing.T) {
+	exporters := []Exporter{
+		{Port: 9090},
+		{Port: 9100, Sidecar: "node-exporter"},
+		{Port: 9200, Sidecar: "missing"},
+		{Port: 9300, JobSuffix: "extra"},
+	}
+	calls := 0
+	found, sidecars, invalid := sidecarExporters(func() ([]Sidecar, error) {
+		calls++
+		return []Sidecar{{Name: "node-exporter", ProcessTypes: []string{"web"}}}, nil
+	}, ScrapeModeInternal, exporters)
+	if !assert.Len(t, found, 3) {
+		return
+	}
+	assert.Equal(t, 1, calls)
+	assert.Len(t, sidecars, 1)
+	assert.Len(t, invalid, 1)
+
+	calls = 0
+	found, sidecars, invalid = sidecarExporters(func() ([]Sidecar, error) {
+		calls++
+		return nil, fmt.Errorf("sidecars: unavailable")
+	}, ScrapeModeInternal, exporters)
+	if !assert.Len(t, found, 2) {
+		return
+	}
+	assert.Equal(t, 9090, found[0].Port)
+	assert.Equal(t, 9300, found[1].Port)
+	assert.Equal(t, 1, calls)
+	assert.Len(t, sidecars, 0)
+	assert.Len(t, invalid, 1)
+}
@@ -0,0 +1,43 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"code.cloudfoundry.org/cli/api/cloudcontroller/ccv3"
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSidecars(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	session, err := clients.NewSession(clients.Config{
+		Endpoint: serverCF.URL,
+		User:     "ron",
+		Password: "swanson",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	sidecars, err := tva.SidecarsRetrieve(session.Raw(), "9e22fe38-38ce-4af6-b529-44d2853d072f")
+	if !assert.Nil(t, err) || !assert.Len(t, sidecars, 1) {
+		return
+	}
+	sidecar, err := tva.FindSidecar(sidecars, "envoy")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"web"}, sidecar.ProcessTypes)
+	_, err = tva.FindSidecar(sidecars, "statsd-exporter")
+	assert.NotNil(t, err)
+
+	processes := sidecar.Processes([]ccv3.Process{
+		{GUID: "web-guid", Type: "web"},
+		{GUID: "worker-guid", Type: "worker"},
+	})
+	if assert.Len(t, processes, 1) {
+		assert.Equal(t, "web-guid", processes[0].GUID)
+	}
+}
@@ -0,0 +1,40 @@
+package tva
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Status is the state of reconciliation as served by the status endpoint
+type Status struct {
+	Reconciles       uint64             `json:"reconciles"`
+	Written          uint64             `json:"written"`
+	Paused           bool               `json:"paused"`
+	QuarantinedRules []QuarantinedRules `json:"quarantined_rules"`
+}
+
+// Status returns the state of reconciliation
+func (t *Timeline) Status() Status {
+	t.Lock()
+	defer t.Unlock()
+	quarantined := make([]QuarantinedRules, len(t.quarantined))
+	copy(quarantined, t.quarantined)
+	return Status{
+		Reconciles:       t.reconciles,
+		Written:          t.written,
+		Paused:           t.paused,
+		QuarantinedRules: quarantined,
+	}
+}
+
+// StatusHandler serves the state of reconciliation, including the apps whose rules are quarantined
+func (t *Timeline) StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(t.Status())
+	}
+}
@@ -2,11 +2,11 @@ package tva
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path"
 	"regexp"
 	"sort"
 	"strconv"
@@ -35,18 +35,41 @@ const (
 	TenantLabel                     = "variant.tva/tenant"
 	RulesLabel                      = "variant.tva/rules"
 	AutoscalerLabel                 = "variant.tva/autoscaler"
+	ExcludeLabel                    = "variant.tva/exclude"
 	AnnotationInstanceName          = "prometheus.exporter.instance_name"
 	AnnotationInstanceSourceRegex   = "prometheus.exporter.instance_source_regex"
 	AnnotationRelabelConfigs        = "prometheus.exporter.relabel_configs"
+	AnnotationMetricRelabelConfigs  = "prometheus.exporter.metric_relabel_configs"
 	AnnotationExporterPort          = "prometheus.exporter.port"
 	AnnotationExporterPath          = "prometheus.exporter.path"
 	AnnotationExporterScheme        = "prometheus.exporter.scheme"
 	AnnotationExporterJobName       = "prometheus.exporter.job_name"
+	AnnotationExporterProcessType   = "prometheus.exporter.process_type"
+	AnnotationExporterSidecar       = "prometheus.exporter.sidecar"
+	AnnotationExporterCredentials   = "prometheus.exporter.credentials"
+	AnnotationTLSCAFile             = "prometheus.exporter.tls.ca_file"
+	AnnotationTLSCertFile           = "prometheus.exporter.tls.cert_file"
+	AnnotationTLSKeyFile            = "prometheus.exporter.tls.key_file"
+	AnnotationTLSServerName         = "prometheus.exporter.tls.server_name"
+	AnnotationTLSInsecureSkipVerify = "prometheus.exporter.tls.insecure_skip_verify"
 	AnnotationExporterScrapInterval = "prometheus.exporter.scrape_interval"
+	AnnotationExporterScrapeTimeout = "prometheus.exporter.scrape_timeout"
+	AnnotationExporterHonorLabels   = "prometheus.exporter.honor_labels"
+	AnnotationExporterSampleLimit   = "prometheus.exporter.sample_limit"
+	AnnotationExporterLabelLimit    = "prometheus.exporter.label_limit"
+	AnnotationExporterTargetLimit   = "prometheus.exporter.target_limit"
+	AnnotationExporterBodySizeLimit = "prometheus.exporter.body_size_limit"
+	AnnotationExporterProtocols     = "prometheus.exporter.scrape_protocols"
+	AnnotationScrapeMode            = "prometheus.exporter.scrape_mode"
+	AnnotationExportersJSON         = "prometheus.exporters.json"
 	AnnotationTargetsPort           = "prometheus.targets.port"
 	AnnotationTargetsPath           = "prometheus.targets.path"
+	AnnotationProbeJSON             = "prometheus.probe.json"
+	AnnotationServiceTargets        = "prometheus.service.targets"
 	AnnotationRulesJSON             = "prometheus.rules.json"
 	AnnotationAutoscalerJSON        = "variant.autoscaler.json"
+	AnnotationAutoscalerMin         = "variant.autoscaler.min"
+	AnnotationAutoscalerMax         = "variant.autoscaler.max"
 	ConfigHashKey                   = "prometheus-config-hash"
 )
 
@@ -57,9 +80,30 @@ var (
 type Config struct {
 	clients.Config
 	PrometheusConfig string
-	InternalDomainID string
+	InternalDomainID string            // Comma separated internal domain GUIDs
+	InternalDomains  string            // Comma separated internal domain names
+	Domains          map[string]string // Resolved internal domain names by GUID
 	ThanosID         string
 	ThanosURL        string
+	InstanceStates   string
+	ScrapeLimits     ScrapeLimits
+	LabelSources     []string // Copy labels from these resources onto targets
+	GUIDLabels       bool     // Add GUID and instance index labels to targets
+	EnvConfig        bool     // Read exporter settings from app environment variables
+	JobNaming        string   // Job naming strategy, see JobName
+	JobNameTemplate  string   // Job name template used with the template strategy
+	BlackboxExporter string   // Address of the blackbox exporter used for probes
+	BlackboxModules  string   // Comma separated blackbox modules apps are allowed to use
+	TLSConfig        promconfig.TLSConfig
+	CredentialsFile  string
+	Credentials      *Credentials // Loaded from CredentialsFile
+	BackupDir        string       // Defaults to the backups folder next to the config
+	BackupRetention  BackupRetention
+	VerifyReload     bool          // Verify Prometheus runs the new config and roll back when it does not
+	ReloadTimeout    time.Duration // How long to wait for Prometheus to pick up a new config
+
+	prefixes             KeyPrefixes         // Set through WithLabelPrefix and WithAnnotationPrefix
+	globalScrapeInterval promconfig.Duration // Read from the global section of the Prometheus config
 }
 
 type Timeline struct {
@@ -68,9 +112,13 @@ type Timeline struct {
 	*cache.Cache
 
 	v1API         v1.API
-	targets       []promconfig.ScrapeConfig
+	targetsMu     sync.RWMutex // Guards targets and origins, so serving them does not wait for a reconcile
+	targets       []ScrapeConfig
+	origins       map[string]App
 	Selectors     []string
 	spaces        []string
+	tenants       []string
+	exclusions    Exclusions
 	autoScalers   map[string][]Autoscaler
 	scalerState   map[string]State
 	defaultTenant bool
@@ -79,33 +127,62 @@ type Timeline struct {
 	startConfig   string
 	config        Config
 	reload        bool
+	fileSDDir     string
+	rulesDir      string
 	debug         bool
 	metrics       Metrics
 	frequency     time.Duration
 	expiresAt     time.Time
+	paused        bool
+	reconciles    uint64 // Number of reconciles so far
+	written       uint64 // The reconcile which last wrote the config
+	rejected      string // Hash of the config and rules last rejected by Prometheus
+	quarantined   []QuarantinedRules
 }
 
 type App struct {
 	resources.Application
-	OrgName   string
-	SpaceName string
+	OrgName          string
+	OrgGUID          string
+	SpaceName        string
+	OrgLabels        map[string]string
+	SpaceLabels      map[string]string
+	OrgAnnotations   map[string]*string
+	SpaceAnnotations map[string]*string
+	EnvAnnotations   map[string]*string
 }
 
 const twoHours = time.Second * 7200
 
+const annotationsTTL = time.Minute
+
 type ruleFiles map[string][]rules.RuleNode
 
 func NewTimeline(config Config, opts ...OptionFunc) (*Timeline, error) {
+	switch config.InstanceStates {
+	case "", InstanceStatesDrop, InstanceStatesLabel:
+	default:
+		return nil, fmt.Errorf("invalid instance states mode: %s", config.InstanceStates)
+	}
+	if err := config.ScrapeLimits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scrape limits: %w", err)
+	}
 	session, err := clients.NewSession(config.Config)
 	if err != nil {
 		return nil, fmt.Errorf("NewTimeline: %w", err)
 	}
+	if config.Domains == nil {
+		config.Domains, err = ResolveInternalDomains(session, splitList(config.InternalDomainID), splitList(config.InternalDomains))
+		if err != nil {
+			return nil, fmt.Errorf("internal domains: %w", err)
+		}
+	}
 	timeline := &Timeline{
 		Session:       session,
 		expiresAt:     time.Now().Add(twoHours),
-		Selectors:     []string{fmt.Sprintf("%s=true", ExporterLabel)},
 		config:        config,
 		knownVariants: make(map[string]bool),
+		origins:       make(map[string]App),
 		autoScalers:   make(map[string][]Autoscaler),
 		scalerState:   make(map[string]State),
 	}
@@ -113,12 +190,19 @@ func NewTimeline(config Config, opts ...OptionFunc) (*Timeline, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read promethues config: %w", err)
 	}
-	var cfg promconfig.Config
+	var cfg PrometheusConfig
 	err = yaml.Unmarshal(data, &cfg)
 
 	if err != nil {
 		return nil, fmt.Errorf("load prometheus config: %w", err)
 	}
+	timeline.config.globalScrapeInterval = cfg.GlobalConfig.ScrapeInterval
+	if config.CredentialsFile != "" {
+		timeline.config.Credentials, err = LoadCredentials(config.CredentialsFile, config.PrometheusConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
 	timeline.startConfig = string(data)
 	timeline.startState = timeline.getCurrentPolicies()
 	for _, p := range timeline.startState {
@@ -129,6 +213,11 @@ func NewTimeline(config Config, opts ...OptionFunc) (*Timeline, error) {
 			return nil, err
 		}
 	}
+	prefixes := timeline.config.prefixes
+	timeline.Selectors = []string{fmt.Sprintf("%s=true", prefixes.LabelKey(ExporterLabel))}
+	if len(timeline.tenants) > 0 {
+		timeline.Selectors = append(timeline.Selectors, fmt.Sprintf("%s in (%s)", prefixes.LabelKey(TenantLabel), strings.Join(timeline.tenants, ",")))
+	}
 	if timeline.debug {
 		fmt.Printf("selectors:\n")
 		for _, s := range timeline.Selectors {
@@ -186,8 +275,6 @@ func (t *Timeline) Start() (done chan bool) {
 }
 
 func (t *Timeline) saveAndReload(newConfig string, files ruleFiles) error {
-	folder := path.Dir(t.config.PrometheusConfig)
-
 	var configData string
 	var keys []string
 	for n := range files {
@@ -195,6 +282,7 @@ func (t *Timeline) saveAndReload(newConfig string, files ruleFiles) error {
 	}
 	// Render in known order
 	sort.Strings(keys)
+	outputs := make(map[string][]byte)
 	for i := 0; i < len(keys); i++ {
 		n := keys[i]
 		r := files[n]
@@ -206,9 +294,11 @@ func (t *Timeline) saveAndReload(newConfig string, files ruleFiles) error {
 				},
 			},
 		}
-		ruleFile := path.Join(folder, n)
-		output, _ := yaml.Marshal(content)
-		_ = os.WriteFile(ruleFile, output, 0644)
+		output, err := yaml.Marshal(content)
+		if err != nil {
+			return fmt.Errorf("marshal rules %s: %w", n, err)
+		}
+		outputs[n] = output
 		configData = configData + string(output)
 	}
 	configData = configData + newConfig
@@ -216,20 +306,55 @@ func (t *Timeline) saveAndReload(newConfig string, files ruleFiles) error {
 	// Generate hashes
 	md5Hash := GetMD5Hash(configData)
 	newHash := GetMD5Hash(newConfig)
+	if md5Hash == t.rejected { // Retrying would only roll back again
+		return fmt.Errorf("%w: config was rejected before", ErrReloadRejected)
+	}
 
 	// Read disk content
-	diskData, _ := os.ReadFile(t.config.PrometheusConfig)
+	diskData, diskErr := os.ReadFile(t.config.PrometheusConfig)
 	diskHash := GetMD5Hash(string(diskData))
+	synced := strings.EqualFold(diskHash, newHash)
+
+	// Save backup before anything is written, so it holds the old config together with the old rule files
+	var backup Backup
+	if !synced || t.ruleFilesChanged(outputs) {
+		if diskErr != nil {
+			return fmt.Errorf("read old config: %w", diskErr)
+		}
+		var err error
+		backup, err = t.saveBackup(diskData)
+		if err != nil {
+			t.incWriteErrors()
+			return fmt.Errorf("save backup: %w", err)
+		}
+	}
+
+	// Rule files go first, so the config never references a missing or partial rule file
+	if err := os.MkdirAll(t.rulesFolder(), 0755); err != nil {
+		t.incWriteErrors()
+		return fmt.Errorf("create rules folder: %w", err)
+	}
+	if err := t.trackRuleFiles(keys...); err != nil {
+		t.incWriteErrors()
+		return err
+	}
+	for _, n := range keys {
+		ruleFile, _ := t.ruleFilePaths(n)
+		if err := writeFileIfChanged(ruleFile, outputs[n], 0644); err != nil {
+			t.incWriteErrors()
+			return fmt.Errorf("save rules %s: %w", ruleFile, err)
+		}
+	}
 
 	_, existing := t.Cache.Get(ConfigHashKey)
 
-	if strings.EqualFold(diskHash, newHash) { // Synced
+	if synced {
 		if existing {
 			if t.metrics != nil {
 				t.metrics.IncConfigCacheHits()
 			}
 		}
-		return nil
+		return t.pruneRuleFiles(files)
 	}
 	if existing { // Out of bound change
 		if t.metrics != nil {
@@ -243,25 +368,28 @@ func (t *Timeline) saveAndReload(newConfig string, files ruleFiles) error {
 		t.metrics.IncConfigLoads()
 	}
 
-	// Save backup
-	backupFile := fmt.Sprintf("%s.%d", t.config.PrometheusConfig, time.Now().Unix())
-	oldData, err := os.ReadFile(t.config.PrometheusConfig)
-	if err != nil {
-		return fmt.Errorf("read old config: %w", err)
-	}
-	if err := os.WriteFile(backupFile, oldData, 0644); err != nil {
-		return fmt.Errorf("save backup %s: %w", backupFile, err)
-	}
 	// Write updated config
-	if err := os.WriteFile(t.config.PrometheusConfig, []byte(newConfig), 0644); err != nil {
+	writtenAt := time.Now()
+	if err := WriteFileAtomic(t.config.PrometheusConfig, []byte(newConfig), 0644); err != nil {
+		t.incWriteErrors()
 		return fmt.Errorf("save config: %w", err)
 	}
-	// Check reload
-	if !t.reload { // Prometheus/Thanos uses inotify
-		return nil
+	t.written = t.reconciles
+	err := t.reloadAndVerify(newConfig, writtenAt)
+	if errors.Is(err, ErrReloadRejected) {
+		if rollbackErr := t.rollback(backup, []byte(newConfig), md5Hash); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback: %w", rollbackErr))
+		}
+		return fmt.Errorf("%w, rolled back to backup %s", err, backup.ID)
 	}
+	if err != nil {
+		return err
+	}
+	return t.pruneRuleFiles(files)
+}
 
-	// Reload
+// reloadPrometheus asks Prometheus to reload its config
+func (t *Timeline) reloadPrometheus() error {
 	resp, err := http.Post(t.config.ThanosURL+"/-/reload", "application/json", nil)
 	if err != nil {
 		return fmt.Errorf("reload config: %w", err)
@@ -270,20 +398,34 @@ func (t *Timeline) saveAndReload(newConfig string, files ruleFiles) error {
 		_ = resp.Body.Close()
 	}()
 	if resp != nil && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("reload config: StatusCode = %d", resp.StatusCode)
+		return fmt.Errorf("%w: StatusCode = %d", ErrReloadRejected, resp.StatusCode)
 	}
 	_, err = io.ReadAll(resp.Body)
 	return err
 }
 
+func (t *Timeline) incWriteErrors() {
+	if t.metrics != nil {
+		t.metrics.IncWriteErrors()
+	}
+}
+
 // Reconcile calculates and applies network-polices and scrap configs
 func (t *Timeline) Reconcile() (string, error) {
 	var foundScrapeConfigs = 0
 	var managedNetworkPolicies = 0
+	var excludedInstances = 0
+	var invalidApps = 0
 
 	t.Lock()
 	defer t.Unlock()
 
+	if t.paused { // A backup was restored
+		fmt.Printf("reconciliation paused\n")
+		return "", nil
+	}
+	t.reconciles++
+
 	var startTime = time.Now()
 	defer func() {
 		duration := time.Since(startTime)
@@ -291,6 +433,8 @@ func (t *Timeline) Reconcile() (string, error) {
 			t.metrics.SetScrapeInterval(float64(duration / time.Millisecond))
 			t.metrics.SetManagedNetworkPolicies(float64(managedNetworkPolicies))
 			t.metrics.SetDetectedScrapeConfigs(float64(foundScrapeConfigs))
+			t.metrics.SetExcludedInstances(float64(excludedInstances))
+			t.metrics.SetInvalidApps(float64(invalidApps))
 			t.metrics.IncTotalIncursions()
 		}
 	}()
@@ -298,6 +442,14 @@ func (t *Timeline) Reconcile() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("session: %w", err)
 	}
+	if t.config.CredentialsFile != "" { // Pick up credential changes, keep the last good ones on error
+		credentials, err := LoadCredentials(t.config.CredentialsFile, t.config.PrometheusConfig)
+		if err != nil {
+			fmt.Printf("error reloading credentials: %v\n", err)
+		} else {
+			t.config.Credentials = credentials
+		}
+	}
 
 	// Retrieve all relevant apps
 	apps, _, err := session.V3().GetApplications(ccv3.Query{
@@ -316,7 +468,7 @@ func (t *Timeline) Reconcile() (string, error) {
 			Key: "label_selector",
 			Values: []string{
 				t.Selectors[0],
-				fmt.Sprintf("!%s", TenantLabel)},
+				fmt.Sprintf("!%s", t.config.prefixes.LabelKey(TenantLabel))},
 		})
 		if err == nil {
 			apps = append(apps, defaultApps...)
@@ -329,7 +481,7 @@ func (t *Timeline) Reconcile() (string, error) {
 	appsWithAutoscalers, _, err := session.V3().GetApplications(ccv3.Query{
 		Key: "label_selector",
 		Values: []string{
-			fmt.Sprintf("%s=true", AutoscalerLabel),
+			fmt.Sprintf("%s=true", t.config.prefixes.LabelKey(AutoscalerLabel)),
 		},
 	})
 	if err != nil {
@@ -340,7 +492,7 @@ func (t *Timeline) Reconcile() (string, error) {
 	appsWithRules, _, err := session.V3().GetApplications(ccv3.Query{
 		Key: "label_selector",
 		Values: []string{
-			fmt.Sprintf("%s=true", RulesLabel),
+			fmt.Sprintf("%s=true", t.config.prefixes.LabelKey(RulesLabel)),
 		},
 	})
 	if err != nil {
@@ -382,57 +534,132 @@ func (t *Timeline) Reconcile() (string, error) {
 		}
 		appsWithAutoscalers = filteredAppsWithAutoscalers
 	}
+	// Filter exclusions
+	apps = t.withoutExcluded(apps)
+	appsWithRules = t.withoutExcluded(appsWithRules)
+	appsWithAutoscalers = t.withoutExcluded(appsWithAutoscalers)
 
 	// Autoscalers
-	for _, app := range appsWithAutoscalers {
-		metadata, err := MetadataRetrieve(session.Raw(), app.GUID)
-		if err != nil {
-			// TODO: record error here
-			continue
-		}
-		scalers, err := ParseAutoscaler(metadata, app.GUID)
-		if err != nil {
-			fmt.Printf("error: %v\n", err)
-			continue
-		}
-		t.autoScalers[app.GUID] = *scalers
-	}
+	t.autoScalers = t.collectAutoscalers(appsWithAutoscalers, func(guid string) (Metadata, error) {
+		return MetadataRetrieve(session.Raw(), guid)
+	})
 	_ = t.evalAutoscalers()
 
 	// Rules
 	ruleFilesToSave := make(ruleFiles)
+	var quarantined []QuarantinedRules
 	for _, app := range appsWithRules {
 		metadata, err := MetadataRetrieve(session.Raw(), app.GUID)
 		if err != nil {
 			// TODO: record error here
 			continue
 		}
-		entries, err := ParseRules(metadata)
-		if err != nil {
-			fmt.Printf("error: %v\n", err)
+		entries, err := ParseRules(t.config.prefixes.Metadata(metadata))
+		if err == nil {
+			err = ValidateRules(entries)
+		}
+		if err != nil { // A single invalid rule set would make Prometheus reject all of them
+			fmt.Printf("app %s (%s): rules quarantined: %v\n", app.Name, app.GUID, err)
+			quarantined = append(quarantined, QuarantinedRules{
+				AppGUID: app.GUID,
+				AppName: app.Name,
+				Error:   err.Error(),
+			})
 			continue
 		}
 		ruleFilesToSave[fmt.Sprintf("%s.yml", app.GUID)] = entries
 	}
+	t.quarantined = quarantined
+	if t.metrics != nil {
+		t.metrics.SetQuarantinedRules(quarantined)
+	}
 
 	if t.debug {
 		fmt.Printf("processing %d apps during this incursion\n", len(apps))
 	}
+	// The config the generated scrape configs are added to
+	var newCfg PrometheusConfig
+
+	err = yaml.Unmarshal([]byte(t.startConfig), &newCfg)
+	if err != nil {
+		if t.metrics != nil {
+			t.metrics.IncErrorIncursions()
+		}
+		return "", fmt.Errorf("loading config: %w", err)
+	}
 	// Determine the desired state
-	var configs []promconfig.ScrapeConfig
+	var configs []ScrapeConfig
 	var generatedPolicies []cfnetv1.Policy
-	for _, app := range apps {
+	origins := make(map[string]App)
+	claimed := staticJobNames(t.startConfig, "static config")
+	for _, app := range byGUID(apps) {
 		// Erase app from startTime if it shows up on the timeline
 		t.startState = PrunePoliciesByDestination(t.startState, app.GUID)
 		// Calculate policies and scrape_config sections for app
-		orgName, spaceName, _ := t.LookupOrgAndSpaceName(app.SpaceGUID)
-		policies, endpoints, _ := GeneratePoliciesAndScrapeConfigs(session, t.config.InternalDomainID, t.config.ThanosID, App{
-			Application: app,
-			SpaceName:   spaceName,
-			OrgName:     orgName,
-		})
+		origin := t.origin(app)
+		policies, endpoints, excluded, err := GeneratePoliciesAndScrapeConfigs(session, t.config, origin)
+		endpoints, validErr := validScrapeConfigs(endpoints, newCfg.GlobalConfig)
+		if validErr != nil {
+			err = errors.Join(err, validErr)
+		}
+		endpoints, claimErr := ClaimJobNames(claimed, origin.GUID, endpoints)
+		if claimErr != nil {
+			err = errors.Join(err, claimErr)
+		}
+		if errors.Is(err, ErrInvalidScrapeSettings) {
+			invalidApps++
+			fmt.Printf("app %s (%s): %v\n", origin.Name, origin.GUID, err)
+		} else if err != nil && t.debug {
+			fmt.Printf("app %s (%s): %v\n", origin.Name, origin.GUID, err)
+		}
+		excludedInstances += excluded
 		generatedPolicies = append(generatedPolicies, policies...)
 		configs = append(configs, endpoints...)
+		for _, e := range endpoints {
+			origins[e.JobName] = origin
+		}
+	}
+	// Service instances, these need no network policies
+	instances, err := t.serviceInstances(session)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].GUID < instances[j].GUID
+	})
+	for _, instance := range instances {
+		org, space, err := t.LookupOrgAndSpace(instance.Relationships.Space.Data.GUID)
+		if err != nil && t.exclusions.bySpaceOrOrg() {
+			fmt.Printf("service instance %s (%s): skipped, exclusions: %v\n", instance.Name, instance.GUID, err)
+			continue
+		}
+		origin := instance.Origin(org, space)
+		if t.exclusions.Excluded(origin.Application, space, org) {
+			continue
+		}
+		endpoint, err := GenerateServiceScrapeConfig(session, t.config, instance, origin)
+		if endpoint != nil {
+			if validErr := ValidateScrapeConfig(*endpoint, newCfg.GlobalConfig); validErr != nil {
+				err = errors.Join(err, invalidScrapeSettings(validErr))
+				endpoint = nil
+			}
+		}
+		if endpoint != nil {
+			if _, claimErr := ClaimJobNames(claimed, instance.GUID, []ScrapeConfig{*endpoint}); claimErr != nil {
+				err = errors.Join(err, claimErr)
+				endpoint = nil
+			}
+		}
+		if errors.Is(err, ErrInvalidScrapeSettings) {
+			invalidApps++
+			fmt.Printf("service instance %s (%s): %v\n", instance.Name, instance.GUID, err)
+		} else if err != nil && t.debug {
+			fmt.Printf("service instance %s (%s): %v\n", instance.Name, instance.GUID, err)
+		}
+		if endpoint != nil {
+			configs = append(configs, *endpoint)
+			origins[endpoint.JobName] = origin
+		}
 	}
 	foundScrapeConfigs = len(configs)
 	managedNetworkPolicies = len(generatedPolicies)
@@ -495,24 +722,47 @@ func (t *Timeline) Reconcile() (string, error) {
 		}
 
 	}
+	t.targetsMu.Lock()
 	t.targets = configs // Refresh the targets list
+	t.origins = origins
+	t.targetsMu.Unlock()
 
 	// Generate new config
-	var newCfg promconfig.Config
-
-	err = yaml.Unmarshal([]byte(t.startConfig), &newCfg)
-	if err != nil {
-		if t.metrics != nil {
-			t.metrics.IncErrorIncursions()
-		}
-		return "", fmt.Errorf("loading config: %w", err)
-	}
+	static := len(newCfg.ScrapeConfigs)
 	for _, cfg := range configs {
 		n := cfg
 		newCfg.ScrapeConfigs = append(newCfg.ScrapeConfigs, &n)
 	}
+	var ruleFileNames []string
 	for r := range ruleFilesToSave {
-		newCfg.RuleFiles = append(newCfg.RuleFiles, r)
+		ruleFileNames = append(ruleFileNames, r)
+	}
+	sort.Strings(ruleFileNames) // Keep the config stable between reconciles
+	for _, r := range ruleFileNames {
+		_, reference := t.ruleFilePaths(r)
+		newCfg.RuleFiles = append(newCfg.RuleFiles, reference)
+	}
+
+	// Validate before writing any file
+	if err := ValidateConfig(newCfg); err != nil {
+		if t.metrics != nil {
+			t.metrics.IncErrorIncursions()
+		}
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+	if t.fileSDDir != "" { // Targets go into file_sd files, so scaling needs no reload
+		configs, err = t.writeTargetFiles(configs, origins)
+		if err != nil {
+			if t.metrics != nil {
+				t.metrics.IncErrorIncursions()
+			}
+			return "", fmt.Errorf("target files: %w", err)
+		}
+		newCfg.ScrapeConfigs = newCfg.ScrapeConfigs[:static]
+		for _, cfg := range configs {
+			n := cfg
+			newCfg.ScrapeConfigs = append(newCfg.ScrapeConfigs, &n)
+		}
 	}
 
 	output, err := yaml.Marshal(newCfg)
@@ -535,6 +785,29 @@ func (t *Timeline) Reconcile() (string, error) {
 	return string(output), nil
 }
 
+// collectAutoscalers returns the autoscalers of the apps, so apps which are gone, excluded or
+// no longer labeled stop being scaled. Apps whose metadata cannot be read keep their current scalers.
+func (t *Timeline) collectAutoscalers(apps []resources.Application, metadataOf func(guid string) (Metadata, error)) map[string][]Autoscaler {
+	autoScalers := make(map[string][]Autoscaler)
+	for _, app := range apps {
+		metadata, err := metadataOf(app.GUID)
+		if err != nil {
+			if scalers, ok := t.autoScalers[app.GUID]; ok {
+				autoScalers[app.GUID] = scalers
+			}
+			continue
+		}
+		metadata, _ = t.origin(app).EffectiveMetadata(t.config.prefixes.Metadata(metadata))
+		scalers, err := ParseAutoscaler(metadata, app.GUID)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			continue
+		}
+		autoScalers[app.GUID] = *scalers
+	}
+	return autoScalers
+}
+
 // This should move to a separate Go routine at some point
 func (t *Timeline) evalAutoscalers() error {
 	session, err := t.session()
@@ -683,11 +956,10 @@ func (t *Timeline) evalAutoscalers() error {
 	return nil
 }
 
-func (t *Timeline) Targets() []promconfig.ScrapeConfig {
-	t.Lock()
-	targets := t.targets
-	defer t.Unlock()
-	return targets
+func (t *Timeline) Targets() []ScrapeConfig {
+	t.targetsMu.RLock()
+	defer t.targetsMu.RUnlock()
+	return t.targets
 }
 
 func (t *Timeline) getCurrentPolicies() []cfnetv1.Policy {
@@ -702,49 +974,104 @@ func (t *Timeline) getCurrentPolicies() []cfnetv1.Policy {
 }
 
 func (t *Timeline) LookupOrgAndSpaceName(guid string) (string, string, error) {
-	spaceName := ""
-	orgName := ""
-	spaceGUID := ""
-	orgGUID := ""
+	org, space, err := t.LookupOrgAndSpace(guid)
+	return org.Name, space.Name, err
+}
+
+// origin returns the app together with the details of its space and org
+func (t *Timeline) origin(app resources.Application) App {
+	org, space, _ := t.LookupOrgAndSpace(app.SpaceGUID)
+	return App{
+		Application:      t.config.prefixes.Application(app),
+		SpaceName:        space.Name,
+		SpaceLabels:      resourceLabels(space.Metadata),
+		SpaceAnnotations: t.annotations("spaces", space.GUID),
+		OrgName:          org.Name,
+		OrgGUID:          org.GUID,
+		OrgLabels:        resourceLabels(org.Metadata),
+		OrgAnnotations:   t.annotations("organizations", org.GUID),
+		EnvAnnotations:   t.envAnnotations(app.GUID),
+	}
+}
+
+// envAnnotations returns the annotations set through the environment variables of the app, when enabled
+func (t *Timeline) envAnnotations(appGUID string) map[string]*string {
+	if !t.config.EnvConfig {
+		return nil
+	}
 	session, err := t.session()
 	if err != nil {
-		return orgName, spaceName, fmt.Errorf("session: %w", err)
+		return nil
 	}
-	// Lookup space first
-	if space, ok := t.Cache.Get(guid); ok {
-		spaceResource := space.(resources.Space)
-		spaceGUID = spaceResource.GUID
-		spaceName = spaceResource.Name
-		orgGUID = spaceResource.Relationships["organization"].GUID
+	annotations, err := EnvAnnotationsRetrieve(session.Raw(), appGUID)
+	if err != nil {
+		fmt.Printf("app %s: %v\n", appGUID, err)
+		return nil
+	}
+	return annotations
+}
+
+// annotations returns the annotations of a space or org. These are cached
+// briefly so changes to defaults are picked up without a restart.
+func (t *Timeline) annotations(kind metadataType, guid string) map[string]*string {
+	if guid == "" {
+		return nil
+	}
+	key := fmt.Sprintf("annotations/%s/%s", kind, guid)
+	if cached, ok := t.Cache.Get(key); ok {
+		return cached.(map[string]*string)
 	}
-	if spaceGUID == "" { // No hit
+	session, err := t.session()
+	if err != nil {
+		return nil
+	}
+	metadata, err := ResourceMetadataRetrieve(session.Raw(), kind, guid)
+	if err != nil {
+		fmt.Printf("error retrieving %s annotations: %v\n", kind, err)
+		return nil
+	}
+	annotations := t.config.prefixes.Annotations(metadata.Annotations)
+	t.Cache.Set(key, annotations, annotationsTTL)
+	return annotations
+}
+
+// LookupOrgAndSpace returns the space with the given guid and its org
+func (t *Timeline) LookupOrgAndSpace(guid string) (resources.Organization, resources.Space, error) {
+	var space resources.Space
+	var organization resources.Organization
+
+	session, err := t.session()
+	if err != nil {
+		return organization, space, fmt.Errorf("session: %w", err)
+	}
+	// Lookup space first
+	if cached, ok := t.Cache.Get(guid); ok {
+		space = cached.(resources.Space)
+	} else { // No hit
 		spaces, _, _, err := session.V3().GetSpaces(ccv3.Query{
 			Key:    "guids",
 			Values: []string{guid},
 		})
 		if err != nil {
-			return orgName, spaceName, fmt.Errorf("space lookup: %w", err)
+			return organization, space, fmt.Errorf("space lookup: %w", err)
 		}
 		if len(spaces) == 0 {
-			return orgName, spaceName, fmt.Errorf("space not found: %s", guid)
+			return organization, space, fmt.Errorf("space not found: %s", guid)
 		}
 		t.Cache.Set(guid, spaces[0], 720*time.Minute)
-		spaceName = spaces[0].Name
-		orgGUID = spaces[0].Relationships["organization"].GUID
+		space = spaces[0]
 	}
-	if org, ok := t.Cache.Get(orgGUID); ok {
-		orgResource := org.(resources.Organization)
-		orgName = orgResource.Name
-		return orgName, spaceName, nil // Cache hit, so we are done!
+	orgGUID := space.Relationships["organization"].GUID
+	if cached, ok := t.Cache.Get(orgGUID); ok {
+		return cached.(resources.Organization), space, nil // Cache hit, so we are done!
 	}
 	// Lookup ORG
-	organization, _, err := session.V3().GetOrganization(orgGUID)
+	organization, _, err = session.V3().GetOrganization(orgGUID)
 	if err != nil {
-		return orgName, spaceName, fmt.Errorf("org lookup: %w", err)
+		return organization, space, fmt.Errorf("org lookup: %w", err)
 	}
 	t.Cache.Set(orgGUID, organization, 720*time.Minute)
-	orgName = organization.Name
-	return orgName, spaceName, nil
+	return organization, space, nil
 }
 
 func NewPolicy(source, destination string, port int) cfnetv1.Policy {
@@ -761,17 +1088,3 @@ func NewPolicy(source, destination string, port int) cfnetv1.Policy {
 func pathMetadata(m metadataType, guid string) string {
 	return fmt.Sprintf("/v3/%s/%s", m, guid)
 }
-
-func InternalHost(session *clients.Session, internalDomainID string, app App) (string, error) {
-	client := session.V2()
-	routes, _, err := client.GetApplicationRoutes(app.GUID)
-	if err != nil {
-		return "", err
-	}
-	for _, r := range routes {
-		if r.DomainGUID == internalDomainID {
-			return fmt.Sprintf("%s.%s", r.Host, "apps.internal"), nil
-		}
-	}
-	return "", fmt.Errorf("no apps.internal route found")
-}
@@ -1,10 +1,12 @@
 package tva_test
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
@@ -30,6 +32,12 @@ var (
 	internalDomainID = "409ec4df-d54d-4a93-8428-94999ecb50bc"
 	thanosID         = "yyy"
 	prometheusConfig = "/tmp/prometheus.yml"
+
+	ceresRuleFor    = "1m"                                  // The for duration of the first rule of ceres, so tests can change its rules
+	ceresProtocols  = "PrometheusProto,PrometheusText0.0.4" // The scrape protocols of ceres
+	ceresInterval   = "30s"                                 // The scrape interval of ceres
+	ceresCredential = ""                                    // The credential referenced by ceres
+	spacesDown      = false                                 // Whether space lookups fail
 )
 
 func setup(t *testing.T) func() {
@@ -87,32 +95,115 @@ scrape_configs:
 		}
 	})
 
-	muxCF.HandleFunc("/v2/apps/9e22fe38-38ce-4af6-b529-44d2853d072f/routes", func(w http.ResponseWriter, r *http.Request) {
+	muxCF.HandleFunc("/v3/service_instances/5d3a8c1e-7f0b-4c43-a6a3-2f6d1f0e9b7a/credentials", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{
+  "uri": "postgres://db.example.com:5432/db",
+  "prometheus_targets": ["db-exporter.example.com:9187", "db-exporter.example.com:9188"]
+}`)
+	})
+
+	muxCF.HandleFunc("/v3/apps/9e22fe38-38ce-4af6-b529-44d2853d072f/sidecars", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{
+  "pagination": {
+    "total_results": 1,
+    "total_pages": 1
+  },
+  "resources": [
+    {
+      "guid": "4bd1f2c6-5f4e-4a4b-9c0e-2d6f0a3b1c7e",
+      "name": "envoy",
+      "command": "envoy -c /etc/envoy.yaml",
+      "process_types": ["web"],
+      "memory_in_mb": 64,
+      "origin": "user"
+    }
+  ]
+}`)
+	})
+
+	muxCF.HandleFunc("/v3/domains", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{
+  "pagination": {
+    "total_results": 2,
+    "total_pages": 1,
+    "next": null,
+    "previous": null
+  },
+  "resources": [
+    {
+      "guid": "409ec4df-d54d-4a93-8428-94999ecb50bc",
+      "name": "apps.internal",
+      "internal": true,
+      "router_group": null,
+      "supported_protocols": ["http"]
+    },
+    {
+      "guid": "0f6a5b3c-3f4e-4b39-9c8e-5d7f2f0b1d21",
+      "name": "example.com",
+      "internal": false,
+      "router_group": null,
+      "supported_protocols": ["http"]
+    }
+  ]
+}`)
+	})
+
+	muxCF.HandleFunc("/v3/apps/9e22fe38-38ce-4af6-b529-44d2853d072f/routes", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = io.WriteString(w, `{
-  "total_results": 1,
-  "total_pages": 1,
-  "prev_url": null,
-  "next_url": null,
+  "pagination": {
+    "total_results": 1,
+    "total_pages": 1,
+    "next": null,
+    "previous": null
+  },
   "resources": [
     {
+      "guid": "2dd5eb59-ecb5-4b88-a92d-f9776e7d495d",
+      "protocol": "http",
+      "created_at": "2021-07-30T09:47:22Z",
+      "updated_at": "2021-07-30T09:47:22Z",
+      "host": "ceres",
+      "path": "",
+      "port": null,
+      "url": "ceres.apps.internal",
+      "destinations": [
+        {
+          "guid": "89323d4e-2e84-43e7-83e9-adbf50a20c0e",
+          "app": {
+            "guid": "9e22fe38-38ce-4af6-b529-44d2853d072f",
+            "process": {
+              "type": "web"
+            }
+          },
+          "weight": null,
+          "port": 8080,
+          "protocol": "http1"
+        }
+      ],
       "metadata": {
-        "guid": "2dd5eb59-ecb5-4b88-a92d-f9776e7d495d",
-        "url": "/v2/routes/2dd5eb59-ecb5-4b88-a92d-f9776e7d495d",
-        "created_at": "2021-07-30T09:47:22Z",
-        "updated_at": "2021-07-30T09:47:22Z"
+        "labels": {},
+        "annotations": {}
+      },
+      "relationships": {
+        "space": {
+          "data": {
+            "guid": "b6b0855f-df85-41c8-8b6f-52b3a1eabb3d"
+          }
+        },
+        "domain": {
+          "data": {
+            "guid": "409ec4df-d54d-4a93-8428-94999ecb50bc"
+          }
+        }
       },
-      "entity": {
-        "host": "ceres",
-        "path": "",
-        "domain_guid": "409ec4df-d54d-4a93-8428-94999ecb50bc",
-        "space_guid": "b6b0855f-df85-41c8-8b6f-52b3a1eabb3d",
-        "service_instance_guid": null,
-        "port": null,
-        "domain_url": "/v2/shared_domains/409ec4df-d54d-4a93-8428-94999ecb50bc",
-        "space_url": "/v2/spaces/b6b0855f-df85-41c8-8b6f-52b3a1eabb3d",
-        "apps_url": "/v2/routes/2dd5eb59-ecb5-4b88-a92d-f9776e7d495d/apps",
-        "route_mappings_url": "/v2/routes/2dd5eb59-ecb5-4b88-a92d-f9776e7d495d/route_mappings"
+      "links": {
+        "self": {
+          "href": "`+serverCF.URL+`/v3/routes/2dd5eb59-ecb5-4b88-a92d-f9776e7d495d"
+        }
       }
     }
   ]
@@ -318,8 +409,10 @@ scrape_configs:
         "annotations": {
           "prometheus.exporter.path": "/metrics",
           "prometheus.exporter.port": "8080",
-          "prometheus.exporter.scrape_interval": "30s",
-		  "prometheus.rules.json": "[{\"annotations\":{\"description\":\"{{ $labels.instance }} waiting http connections is at {{ $value }}\",\"summary\":\"Instance {{ $labels.instance }} has more than 2 waiting connections per minute\"},\"expr\":\"kong_nginx_http_current_connections{state=\\\"waiting\\\"} \\u003e 2\",\"for\":\"1m\",\"labels\":{\"severity\":\"critical\"},\"alert\":\"KongWaitingConnections\"}]",
+          "prometheus.exporter.scrape_interval": "`+ceresInterval+`",
+          "prometheus.exporter.scrape_protocols": "`+ceresProtocols+`",
+          "prometheus.exporter.credentials": "`+ceresCredential+`",
+		  "prometheus.rules.json": "[{\"annotations\":{\"description\":\"{{ $labels.instance }} waiting http connections is at {{ $value }}\",\"summary\":\"Instance {{ $labels.instance }} has more than 2 waiting connections per minute\"},\"expr\":\"kong_nginx_http_current_connections{state=\\\"waiting\\\"} \\u003e 2\",\"for\":\"`+ceresRuleFor+`\",\"labels\":{\"severity\":\"critical\"},\"alert\":\"KongWaitingConnections\"}]",
           "prometheus.rules.1.json": "{\"alert\":\"TransactionsHSDPPG\",\"annotations\":{\"description\":\"{{ $labels.instance }}, this is just a test alert\",\"summary\":\"Instance {{ $labels.instance }} has high transaction rate\"},\"expr\":\"irate(pg_stat_database_xact_commit{datname=~\\\"hsdp_pg\\\"}[5m]) \\u003e 8\",\"for\":\"1m\",\"labels\":{\"severity\":\"critical\"}}",
           "prometheus.exporter.relabel_configs": "[{\"source_labels\": [\"__name__\"], \"regex\":\"^(go|process).*$\", \"action\": \"drop\"}]"
         }
@@ -636,7 +729,8 @@ func orgHandler(w http.ResponseWriter, r *http.Request) {
 
     },
     "annotations": {
-
+      "variant.autoscaler.max": "5",
+      "prometheus.rules.json": "[]"
     }
   },
   "links": {
@@ -661,6 +755,10 @@ func orgHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func spacesHandler(w http.ResponseWriter, r *http.Request) {
+	if spacesDown {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		w.WriteHeader(http.StatusOK)
@@ -826,6 +924,7 @@ func TestReconcile(t *testing.T) {
 	}
 	assert.Equal(t, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.StaticConfigs[0].Labels["cf_org_name"], "test-org")
 	assert.Equal(t, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.StaticConfigs[0].Labels["cf_space_name"], "test-space")
+	assert.Equal(t, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.StaticConfigs[0].Labels["cf_process_type"], "web")
 	assert.Len(t, cfg.ScrapeConfigs[2].RelabelConfigs, 1)
 	assert.Equal(t, promconfig.Duration(30*time.Second), cfg.ScrapeConfigs[2].ScrapeInterval)
 }
@@ -882,6 +981,152 @@ func TestWithSpaces(t *testing.T) {
 	assert.Equal(t, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.StaticConfigs[0].Labels["cf_space_name"], "test-space")
 }
 
+func TestWithExclusions(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	config := tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}
+
+	_, err := tva.NewTimeline(config, tva.WithExcludeOrgs("[test"), tva.WithReload(false))
+	assert.NotNil(t, err)
+
+	timeline, err := tva.NewTimeline(config,
+		tva.WithDebug(true),
+		tva.WithFrequency(5),
+		tva.WithTenants("default"),
+		tva.WithExcludeSpaces("test-*"),
+		tva.WithReload(false),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	var cfg promconfig.Config
+
+	err = yaml.Unmarshal([]byte(output), &cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	// Only the static scrape configs remain
+	assert.Len(t, cfg.ScrapeConfigs, 2)
+
+	// Apps whose space and org cannot be looked up are skipped, as they might be excluded
+	spacesDown = true
+	defer func() { spacesDown = false }()
+	timeline, err = tva.NewTimeline(config,
+		tva.WithTenants("default"),
+		tva.WithExcludeOrgs("sandbox-*"),
+		tva.WithReload(false),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	output, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	cfg = promconfig.Config{}
+	if !assert.Nil(t, yaml.Unmarshal([]byte(output), &cfg)) {
+		return
+	}
+	assert.Len(t, cfg.ScrapeConfigs, 2)
+}
+
+func TestInvalidScrapeConfig(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+	defer func() { ceresProtocols = "PrometheusProto,PrometheusText0.0.4" }()
+
+	config := tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}
+	timeline, err := tva.NewTimeline(config,
+		tva.WithTenants("default"),
+		tva.WithReload(false),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Prometheus refuses duplicate protocols, so ceres is left out instead of failing the reconcile
+	ceresProtocols = "PrometheusProto,PrometheusProto"
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	var cfg promconfig.Config
+
+	err = yaml.Unmarshal([]byte(output), &cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, cfg.ScrapeConfigs, 2)
+}
+
+func TestInvalidScrapeInterval(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+	defer func() { ceresInterval = "30s" }()
+
+	config := tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}
+	timeline, err := tva.NewTimeline(config,
+		tva.WithTenants("default"),
+		tva.WithReload(false),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Only the interval is skipped, ceres is still scraped at the global interval
+	ceresInterval = "thirty seconds"
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	var cfg promconfig.Config
+
+	err = yaml.Unmarshal([]byte(output), &cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Len(t, cfg.ScrapeConfigs, 3) {
+		return
+	}
+	assert.Equal(t, promconfig.Duration(0), cfg.ScrapeConfigs[2].ScrapeInterval)
+}
+
 func TestWithBogusSpaces(t *testing.T) {
 	teardown := setup(t)
 	defer teardown()
@@ -962,3 +1207,98 @@ func TestConfigCache(t *testing.T) {
 	assert.Equal(t, "245723b6792bcde29b29fc7686723bca", md5Cache)
 
 }
+
+func TestWithFileSD(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	config := tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+	}
+	targetsDir := t.TempDir()
+	// Legacy target file of ceres before it was renamed
+	stale := filepath.Join(targetsDir, "vesta-9e22fe38.json")
+	_ = os.WriteFile(stale, []byte(`[]`), 0644)
+	// Without a manifest only target files of discovered apps are taken over
+	foreign := filepath.Join(targetsDir, "gone-12345678.json")
+	_ = os.WriteFile(foreign, []byte(`[]`), 0644)
+	// Files which variant did not write stay, e.g. when rules go to the same folder
+	operator := filepath.Join(targetsDir, "hosts.json")
+	_ = os.WriteFile(operator, []byte(`[]`), 0644)
+	rulesManifest := filepath.Join(targetsDir, ".variant-rules.json")
+	_ = os.WriteFile(rulesManifest, []byte(`[]`), 0644)
+
+	timeline, err := tva.NewTimeline(config,
+		tva.WithTenants("default"),
+		tva.WithFileSD(targetsDir),
+		tva.WithReload(false),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	var cfg promconfig.Config
+
+	err = yaml.Unmarshal([]byte(output), &cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Len(t, cfg.ScrapeConfigs, 3) {
+		return
+	}
+	assert.Len(t, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.StaticConfigs, 0)
+	if !assert.Len(t, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.FileSDConfigs, 1) {
+		return
+	}
+	targetFile := filepath.Join(targetsDir, "ceres-9e22fe38.json")
+	assert.Equal(t, []string{targetFile}, cfg.ScrapeConfigs[2].ServiceDiscoveryConfig.FileSDConfigs[0].Files)
+
+	data, err := os.ReadFile(targetFile)
+	if !assert.Nil(t, err) {
+		return
+	}
+	var groups []tva.TargetGroup
+	if !assert.Nil(t, json.Unmarshal(data, &groups)) {
+		return
+	}
+	if !assert.Len(t, groups, 1) {
+		return
+	}
+	assert.Equal(t, []string{"0.ceres.apps.internal:8080"}, groups[0].Targets)
+	assert.Equal(t, "test-org", groups[0].Labels["cf_org_name"])
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, operator)
+	assert.FileExists(t, rulesManifest)
+	assert.FileExists(t, foreign)
+
+	data, err = os.ReadFile(filepath.Join(targetsDir, ".variant-targets.json"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	var owned []string
+	if assert.Nil(t, json.Unmarshal(data, &owned)) {
+		assert.Equal(t, []string{"ceres-9e22fe38.json"}, owned)
+	}
+
+	// Once a manifest exists only the files listed in it are removed
+	_ = os.WriteFile(stale, []byte(`[]`), 0644)
+	_, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.FileExists(t, stale)
+	assert.FileExists(t, operator)
+}
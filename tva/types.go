@@ -1,9 +1,77 @@
 package tva
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+
 	"github.com/percona/promconfig"
 )
 
+// Exporter describes a single metrics endpoint of an app
+type Exporter struct {
+	Port                 int              `json:"port"`
+	Path                 string           `json:"path,omitempty"`
+	Scheme               string           `json:"scheme,omitempty"`
+	Interval             string           `json:"interval,omitempty"`
+	JobSuffix            string           `json:"job_suffix,omitempty"`
+	ProcessType          string           `json:"process_type,omitempty"`
+	Sidecar              string           `json:"sidecar,omitempty"`
+	RelabelConfigs       []*RelabelConfig `json:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []*RelabelConfig `json:"metric_relabel_configs,omitempty"`
+	TLSConfig            *TLSConfig       `json:"tls_config,omitempty"`
+	Credentials          string           `json:"credentials,omitempty"`
+	ScrapeTuning
+}
+
+// TLSConfig holds the TLS client settings used to scrape an exporter
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify *bool  `json:"insecure_skip_verify,omitempty"`
+}
+
+// ToProm returns the TLS config with the operator defaults applied to all unset fields
+func (c *TLSConfig) ToProm(defaults promconfig.TLSConfig) promconfig.TLSConfig {
+	dest := defaults
+	if c == nil {
+		return dest
+	}
+	if c.CAFile != "" {
+		dest.CAFile = c.CAFile
+	}
+	if c.CertFile != "" {
+		dest.CertFile = c.CertFile
+	}
+	if c.KeyFile != "" {
+		dest.KeyFile = c.KeyFile
+	}
+	if c.ServerName != "" {
+		dest.ServerName = c.ServerName
+	}
+	if c.InsecureSkipVerify != nil {
+		dest.InsecureSkipVerify = *c.InsecureSkipVerify
+	}
+	return dest
+}
+
+// Relabel actions supported by Prometheus
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelKeepEqual = "keepequal"
+	RelabelDropEqual = "dropequal"
+	RelabelHashMod   = "hashmod"
+	RelabelLabelMap  = "labelmap"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+	RelabelLowercase = "lowercase"
+	RelabelUppercase = "uppercase"
+)
+
 type RelabelConfig struct {
 	SourceLabels []string `json:"source_labels,omitempty"`
 	Separator    string   `json:"separator,omitempty"`
@@ -14,6 +82,84 @@ type RelabelConfig struct {
 	Action       string   `json:"action,omitempty"`
 }
 
+var (
+	// labelNameRE matches valid label names
+	labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// relabelTargetRE matches label names which may contain $1 or ${name} references
+	relabelTargetRE = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+)
+
+// Defaults Prometheus applies to unset relabel config fields
+const (
+	defaultRelabelSeparator   = ";"
+	defaultRelabelRegex       = "(.*)"
+	defaultRelabelReplacement = "$1"
+)
+
+// Validate checks the relabel config the same way Prometheus does when
+// loading its config, so a bad annotation cannot break the whole config.
+// Unset fields and fields set to their Prometheus default are treated alike.
+func (r *RelabelConfig) Validate() error {
+	action := r.Action
+	if action == "" {
+		action = RelabelReplace
+	}
+	for _, l := range r.SourceLabels {
+		if !labelNameRE.MatchString(l) {
+			return fmt.Errorf("%q is not a valid label name in source_labels", l)
+		}
+	}
+	if r.Regex != "" {
+		if _, err := regexp.Compile("^(?:" + r.Regex + ")$"); err != nil {
+			return fmt.Errorf("invalid regex %q: %w", r.Regex, err)
+		}
+	}
+	defaultSeparator := r.Separator == "" || r.Separator == defaultRelabelSeparator
+	defaultRegex := r.Regex == "" || r.Regex == defaultRelabelRegex
+	defaultReplacement := r.Replacement == "" || r.Replacement == defaultRelabelReplacement
+	switch action {
+	case RelabelReplace, RelabelLowercase, RelabelUppercase, RelabelKeepEqual, RelabelDropEqual:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires target_label", action)
+		}
+		if !relabelTargetRE.MatchString(r.TargetLabel) {
+			return fmt.Errorf("%q is invalid target_label for relabel action %s", r.TargetLabel, action)
+		}
+	case RelabelHashMod:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires target_label", action)
+		}
+		if !labelNameRE.MatchString(r.TargetLabel) {
+			return fmt.Errorf("%q is invalid target_label for relabel action %s", r.TargetLabel, action)
+		}
+		if r.Modulus == 0 {
+			return fmt.Errorf("relabel action hashmod requires non-zero modulus")
+		}
+	case RelabelLabelMap:
+		if !defaultReplacement && !relabelTargetRE.MatchString(r.Replacement) {
+			return fmt.Errorf("%q is invalid replacement for relabel action %s", r.Replacement, action)
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("unknown relabel action %q", r.Action)
+	}
+	switch action {
+	case RelabelKeepEqual, RelabelDropEqual:
+		if !defaultRegex || r.Modulus != 0 || !defaultSeparator || !defaultReplacement {
+			return fmt.Errorf("relabel action %s only supports source_labels and target_label", action)
+		}
+	case RelabelLabelDrop, RelabelLabelKeep:
+		if len(r.SourceLabels) > 0 || r.TargetLabel != "" || r.Modulus != 0 || !defaultSeparator || !defaultReplacement {
+			return fmt.Errorf("relabel action %s only supports regex", action)
+		}
+	case RelabelLowercase, RelabelUppercase:
+		if !defaultReplacement {
+			return fmt.Errorf("relabel action %s does not support replacement", action)
+		}
+	}
+	return nil
+}
+
 func (r *RelabelConfig) ToProm() *promconfig.RelabelConfig {
 	dest := &promconfig.RelabelConfig{
 		SourceLabels: r.SourceLabels,
@@ -26,3 +172,41 @@ func (r *RelabelConfig) ToProm() *promconfig.RelabelConfig {
 	}
 	return dest
 }
+
+// FromProm returns the relabel config of a Prometheus relabel config
+func FromProm(r *promconfig.RelabelConfig) *RelabelConfig {
+	return &RelabelConfig{
+		SourceLabels: r.SourceLabels,
+		Separator:    r.Separator,
+		Regex:        r.Regex,
+		Modulus:      r.Modulus,
+		TargetLabel:  r.TargetLabel,
+		Replacement:  r.Replacement,
+		Action:       r.Action,
+	}
+}
+
+// relabelConfigs validates the relabel configs, returning the valid ones
+// in Prometheus format together with the errors of the invalid ones
+func relabelConfigs(field string, configs []*RelabelConfig) ([]*promconfig.RelabelConfig, error) {
+	var result []*promconfig.RelabelConfig
+	var invalid []error
+	for i, r := range configs {
+		if r == nil {
+			continue
+		}
+		if err := r.Validate(); err != nil {
+			invalid = append(invalid, fmt.Errorf("%s %d: %w", field, i, err))
+			continue
+		}
+		result = append(result, r.ToProm())
+	}
+	return result, errors.Join(invalid...)
+}
+
+// TargetGroup is the JSON representation of a target group as used by
+// Prometheus file_sd_configs and http_sd_configs
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
@@ -0,0 +1,45 @@
+package tva_test
+
+import (
+	"testing"
+	"variant/tva"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelabelConfigValidate(t *testing.T) {
+	valid := []tva.RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_.*", Action: "drop"},
+		{SourceLabels: []string{"instance"}, TargetLabel: "host", Replacement: "$1"},
+		{SourceLabels: []string{"__address__"}, TargetLabel: "__tmp_hash", Modulus: 4, Action: "hashmod"},
+		{Regex: "__meta_(.+)", Replacement: "$1", Action: "labelmap"},
+		{Regex: "pod_.*", Action: "labeldrop"},
+		{SourceLabels: []string{"port"}, TargetLabel: "__meta_port", Action: "keepequal"},
+		{SourceLabels: []string{"env"}, TargetLabel: "env", Action: "lowercase"},
+		{SourceLabels: []string{"zone"}, TargetLabel: "zone_${1}", Regex: "(.+)", Replacement: "x"},
+		{SourceLabels: []string{"port"}, TargetLabel: "port", Separator: ";", Action: "dropequal"},
+		{Regex: "tmp_.*", Separator: ";", Replacement: "$1", Action: "labeldrop"},
+	}
+	for _, r := range valid {
+		assert.Nil(t, r.Validate(), r.Action)
+	}
+
+	invalid := []tva.RelabelConfig{
+		{Action: "dropall"},
+		{SourceLabels: []string{"__name__"}, Regex: "go_(.*", Action: "drop"},
+		{SourceLabels: []string{"instance"}, Action: "replace"},
+		{SourceLabels: []string{"__address__"}, TargetLabel: "__tmp_hash", Action: "hashmod"},
+		{SourceLabels: []string{"port"}, TargetLabel: "__meta_port", Regex: ".*", Action: "keepequal"},
+		{SourceLabels: []string{"pod"}, Regex: "pod_.*", Action: "labelkeep"},
+		{SourceLabels: []string{"instance"}, TargetLabel: "foo-bar", Action: "replace"},
+		{SourceLabels: []string{"instance"}, TargetLabel: "1host", Action: "lowercase"},
+		{SourceLabels: []string{"__address__"}, TargetLabel: "hash_$1", Modulus: 4, Action: "hashmod"},
+		{SourceLabels: []string{"port"}, TargetLabel: "port-2", Action: "keepequal"},
+		{SourceLabels: []string{"port"}, TargetLabel: "port", Separator: ",", Action: "dropequal"},
+		{Regex: "__meta_(.+)", Replacement: "a-$1", Action: "labelmap"},
+		{SourceLabels: []string{"cf-app"}, TargetLabel: "app"},
+	}
+	for _, r := range invalid {
+		assert.NotNil(t, r.Validate(), r.Action)
+	}
+}
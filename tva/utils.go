@@ -5,8 +5,10 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -52,6 +54,16 @@ func UniqPolicies(policies []cfnetv1.Policy) []cfnetv1.Policy {
 	return result
 }
 
+// AppTenant returns the tenant the app is associated with
+func AppTenant(app resources.Application) string {
+	if app.Metadata != nil {
+		if tenant, ok := app.Metadata.Labels[TenantLabel]; ok && tenant.IsSet {
+			return tenant.Value
+		}
+	}
+	return "default"
+}
+
 func ContainsString(haystack []string, needle string) bool {
 	for _, a := range haystack {
 		if strings.EqualFold(a, needle) {
@@ -91,7 +103,12 @@ func PolicyEqual(a, b cfnetv1.Policy) bool {
 }
 
 func MetadataRetrieve(client *clients.RawClient, guid string) (Metadata, error) {
-	req, err := client.NewRequest("GET", pathMetadata("apps", guid), nil)
+	return ResourceMetadataRetrieve(client, "apps", guid)
+}
+
+// ResourceMetadataRetrieve returns the metadata of a CF resource such as an app, space or organization
+func ResourceMetadataRetrieve(client *clients.RawClient, kind metadataType, guid string) (Metadata, error) {
+	req, err := client.NewRequest("GET", pathMetadata(kind, guid), nil)
 	if err != nil {
 		return Metadata{}, err
 	}
@@ -131,6 +148,36 @@ func MetadataRetrieve(client *clients.RawClient, guid string) (Metadata, error)
 	return metadataReq.Metadata, nil
 }
 
+// RawRetrieve performs a GET request against the CF API and decodes the JSON response into v
+func RawRetrieve(client *clients.RawClient, path string, v interface{}) error {
+	req, err := client.NewRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return ccerror.RawHTTPStatusError{
+			StatusCode:  resp.StatusCode,
+			RawResponse: b,
+		}
+	}
+	return json.Unmarshal(b, v)
+}
+
 func ParseAutoscaler(metadata Metadata, appGUID string) (*[]Autoscaler, error) {
 	var scalers []Autoscaler
 	scalerJSON := metadata.Annotations[AnnotationAutoscalerJSON]
@@ -142,8 +189,25 @@ func ParseAutoscaler(metadata Metadata, appGUID string) (*[]Autoscaler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("decoding scaler JSON: %w", err)
 	}
+	// Bounds set as annotation, usually on the space or org
+	var bounds [2]int
+	for i, annotation := range []string{AnnotationAutoscalerMin, AnnotationAutoscalerMax} {
+		if value := metadata.Annotations[annotation]; value != nil {
+			bound, err := strconv.Atoi(*value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", annotation, err)
+			}
+			bounds[i] = bound
+		}
+	}
 	// Defaults
 	for i := 0; i < len(scalers); i++ {
+		if scalers[i].Min == 0 {
+			scalers[i].Min = bounds[0]
+		}
+		if scalers[i].Max == 0 {
+			scalers[i].Max = bounds[1]
+		}
 		if scalers[i].Min < 1 {
 			scalers[i].Min = 1
 		}
@@ -164,6 +228,9 @@ func ParseAutoscaler(metadata Metadata, appGUID string) (*[]Autoscaler, error) {
 	return &scalers, nil
 }
 
+// ParseRules returns the rules of the rules JSON annotation followed by those of the
+// indexed rule annotations. Indexed annotations which do not parse are skipped and
+// returned as ErrInvalidScrapeSettings together with the other rules.
 func ParseRules(metadata Metadata) ([]rules.RuleNode, error) {
 	var foundRules []rules.RuleNode
 
@@ -174,33 +241,180 @@ func ParseRules(metadata Metadata) ([]rules.RuleNode, error) {
 			return foundRules, err
 		}
 	}
-	// Add indexed entries as well
-	for k, v := range metadata.Annotations {
+	// Add indexed entries as well, in a stable order
+	var indexed []string
+	for k := range metadata.Annotations {
 		if AnnotationRulesIndexJSONRegex.MatchString(k) {
-			var rule rules.RuleNode
-			err := json.NewDecoder(bytes.NewBufferString(*v)).Decode(&rule)
-			if err != nil {
-				continue
-			}
-			foundRules = append(foundRules, rule)
+			indexed = append(indexed, k)
+		}
+	}
+	sort.Strings(indexed)
+	var invalid []error
+	for _, k := range indexed {
+		var rule rules.RuleNode
+		err := json.NewDecoder(bytes.NewBufferString(*metadata.Annotations[k])).Decode(&rule)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("%s: %w", k, err))
+			continue
 		}
+		foundRules = append(foundRules, rule)
 	}
 
-	return foundRules, nil
+	return foundRules, invalidScrapeSettings(invalid...)
 }
 
 func MetricsEndpointBasicAuthEnabled() bool {
 	return viper.GetString("basic_auth_username") != "" && viper.GetString("basic_auth_password") != ""
 }
 
-func GeneratePoliciesAndScrapeConfigs(session *clients.Session, internalDomainID, source string, app App) ([]cfnetv1.Policy, []promconfig.ScrapeConfig, error) {
+// ParseExporters returns the exporter endpoints of an app. The single endpoint
+// annotations make up the first entry, followed by the entries of the
+// exporters JSON annotation. The first entry is omitted when only the JSON
+// annotation is used. Invalid settings are skipped, the remaining exporters
+// are still returned together with an ErrInvalidScrapeSettings error.
+func ParseExporters(metadata Metadata) ([]Exporter, error) {
+	var exporters []Exporter
+	var invalid []error
+
+	exportersJSON := metadata.Annotations[AnnotationExportersJSON]
+	if exportersJSON == nil || metadata.Annotations[AnnotationExporterPort] != nil {
+		exporter := Exporter{
+			Port:   9090,       // Default
+			Path:   "/metrics", // Default
+			Scheme: "http",     // Default
+		}
+		validPort := true
+		if port := metadata.Annotations[AnnotationExporterPort]; port != nil {
+			portNumber, err := strconv.Atoi(*port)
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("%s: %w", AnnotationExporterPort, err))
+				validPort = false
+			}
+			exporter.Port = portNumber
+		}
+		if exporterPath := metadata.Annotations[AnnotationExporterPath]; exporterPath != nil {
+			exporter.Path = *exporterPath
+		}
+		if processType := metadata.Annotations[AnnotationExporterProcessType]; processType != nil {
+			exporter.ProcessType = *processType
+		}
+		if sidecar := metadata.Annotations[AnnotationExporterSidecar]; sidecar != nil {
+			exporter.Sidecar = *sidecar
+		}
+		if schema := metadata.Annotations[AnnotationExporterScheme]; schema != nil {
+			exporter.Scheme = *schema
+		}
+		if scrapeInterval := metadata.Annotations[AnnotationExporterScrapInterval]; scrapeInterval != nil {
+			exporter.Interval = *scrapeInterval
+		}
+		if relabelConfigs := metadata.Annotations[AnnotationRelabelConfigs]; relabelConfigs != nil {
+			err := json.Unmarshal([]byte(*relabelConfigs), &exporter.RelabelConfigs)
+			if err != nil {
+				exporter.RelabelConfigs = nil
+				invalid = append(invalid, fmt.Errorf("%s: %w", AnnotationRelabelConfigs, err))
+			}
+		}
+		if metricRelabelConfigs := metadata.Annotations[AnnotationMetricRelabelConfigs]; metricRelabelConfigs != nil {
+			err := json.Unmarshal([]byte(*metricRelabelConfigs), &exporter.MetricRelabelConfigs)
+			if err != nil {
+				exporter.MetricRelabelConfigs = nil
+				invalid = append(invalid, fmt.Errorf("%s: %w", AnnotationMetricRelabelConfigs, err))
+			}
+		}
+		if credentials := metadata.Annotations[AnnotationExporterCredentials]; credentials != nil {
+			exporter.Credentials = *credentials
+		}
+		tlsConfig, err := ParseTLSConfig(metadata)
+		if err != nil {
+			invalid = append(invalid, err)
+		}
+		exporter.TLSConfig = tlsConfig
+		tuning, err := ParseScrapeTuning(metadata)
+		if err != nil {
+			invalid = append(invalid, err)
+		}
+		exporter.ScrapeTuning = tuning
+		if validPort {
+			exporters = append(exporters, exporter)
+		}
+	}
+	if exportersJSON != nil {
+		var entries []Exporter
+		err := json.NewDecoder(bytes.NewBufferString(*exportersJSON)).Decode(&entries)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("decoding exporters JSON: %w", err))
+			entries = nil
+		}
+		for i := 0; i < len(entries); i++ {
+			if entries[i].Port <= 0 {
+				invalid = append(invalid, fmt.Errorf("exporter %d: missing port", i))
+				continue
+			}
+			// Defaults
+			if entries[i].Path == "" {
+				entries[i].Path = "/metrics"
+			}
+			if entries[i].Scheme == "" {
+				entries[i].Scheme = "http"
+			}
+			if entries[i].JobSuffix == "" && entries[i].Sidecar != "" {
+				entries[i].JobSuffix = entries[i].Sidecar
+			}
+			if entries[i].JobSuffix == "" && len(exporters) > 0 {
+				entries[i].JobSuffix = strconv.Itoa(entries[i].Port)
+			}
+			exporters = append(exporters, entries[i])
+		}
+	}
+	return exporters, invalidScrapeSettings(invalid...)
+}
+
+// ParseTLSConfig returns the TLS client settings from the exporter TLS annotations,
+// or nil when none are set. An invalid value is skipped and returned as error.
+func ParseTLSConfig(metadata Metadata) (*TLSConfig, error) {
+	var tlsConfig TLSConfig
+	found := false
+	for annotation, field := range map[string]*string{
+		AnnotationTLSCAFile:     &tlsConfig.CAFile,
+		AnnotationTLSCertFile:   &tlsConfig.CertFile,
+		AnnotationTLSKeyFile:    &tlsConfig.KeyFile,
+		AnnotationTLSServerName: &tlsConfig.ServerName,
+	} {
+		if value := metadata.Annotations[annotation]; value != nil {
+			*field = *value
+			found = true
+		}
+	}
+	var invalid error
+	if insecure := metadata.Annotations[AnnotationTLSInsecureSkipVerify]; insecure != nil {
+		skipVerify, err := strconv.ParseBool(*insecure)
+		if err != nil {
+			invalid = fmt.Errorf("%s: %w", AnnotationTLSInsecureSkipVerify, err)
+		} else {
+			tlsConfig.InsecureSkipVerify = &skipVerify
+			found = true
+		}
+	}
+	if !found {
+		return nil, invalid
+	}
+	return &tlsConfig, invalid
+}
+
+// GeneratePoliciesAndScrapeConfigs returns the network policies and scrape configs
+// required to scrape the app, and the number of instances excluded from scraping.
+// Invalid scrape settings are skipped, the configs are still returned together
+// with an ErrInvalidScrapeSettings error.
+func GeneratePoliciesAndScrapeConfigs(session *clients.Session, config Config, app App) ([]cfnetv1.Policy, []ScrapeConfig, int, error) {
 	var policies []cfnetv1.Policy
-	var configs []promconfig.ScrapeConfig
+	var configs []ScrapeConfig
+	var invalid []error
 
+	source := config.ThanosID
 	instanceCount := 0
 	processes, _, err := session.V3().GetApplicationProcesses(app.GUID)
 	if err != nil {
-		return policies, configs, err
+		return policies, configs, 0, err
 	}
 	for _, p := range processes {
 		if p.Instances.IsSet && p.Instances.Value > instanceCount {
@@ -208,143 +422,233 @@ func GeneratePoliciesAndScrapeConfigs(session *clients.Session, internalDomainID
 		}
 	}
 	if instanceCount == 0 {
-		return policies, configs, fmt.Errorf("no instances found")
+		return policies, configs, 0, fmt.Errorf("no instances found")
+	}
+	states := instanceStates{mode: config.InstanceStates, index: config.GUIDLabels}
+	if states.mode != "" {
+		states.states, err = InstanceStatesRetrieve(session, processes)
+		if err != nil {
+			return policies, configs, 0, err
+		}
 	}
 	rawClient := session.Raw()
 	metadata, err := MetadataRetrieve(rawClient, app.GUID)
 	if err != nil {
-		return policies, configs, fmt.Errorf("metadataRetrieve: %w", err)
+		return policies, configs, 0, fmt.Errorf("metadataRetrieve: %w", err)
 	}
-	portNumber := 9090 // Default
-	if port := metadata.Annotations[AnnotationExporterPort]; port != nil {
-		portNumber, err = strconv.Atoi(*port)
-		if err != nil {
-			return policies, configs, err
-		}
+	metadata, _ = app.EffectiveMetadata(config.prefixes.Metadata(metadata))
+	exporters, err := ParseExporters(metadata)
+	if err != nil {
+		invalid = append(invalid, err)
 	}
-	scrapePath := "/metrics" // Default
-	if exporterPath := metadata.Annotations[AnnotationExporterPath]; exporterPath != nil {
-		scrapePath = *exporterPath
+	mode, err := ParseScrapeMode(metadata)
+	if err != nil {
+		return policies, configs, 0, invalidScrapeSettings(err)
 	}
-	jobName := app.Name // Default
-	if name := metadata.Annotations[AnnotationExporterJobName]; name != nil {
-		jobName = *name
+
+	routes, err := AppRoutes(session, app)
+	if err != nil {
+		return policies, configs, 0, err
+	}
+	probes, err := ParseProbes(metadata)
+	if err != nil {
+		invalid = append(invalid, err)
+	}
+	if len(probes) > 0 {
+		probeConfig, err := GenerateProbeConfig(config, app, JobName(config, app, metadata, "probe"), probes, routes, TargetLabels(config, app, metadata.Labels))
+		if err != nil {
+			invalid = append(invalid, err)
+		}
+		if probeConfig != nil {
+			configs = append(configs, *probeConfig)
+		}
 	}
 
-	appGUID := strings.Split(app.GUID, "-")[0]
-	jobName = fmt.Sprintf("%s-%s", jobName, appGUID) // Ensure uniqueness across spaces
+	exporters, sidecars, sidecarErrs := sidecarExporters(func() ([]Sidecar, error) {
+		return SidecarsRetrieve(rawClient, app.GUID)
+	}, mode, exporters)
+	invalid = append(invalid, sidecarErrs...)
 
-	scheme := "http" // Default
-	if schema := metadata.Annotations[AnnotationExporterScheme]; schema != nil {
-		scheme = *schema
+	probeConfigs := len(configs)
+	var internalHosts map[string]string
+	reachableHosts := make(map[string]string)
+	if mode == ScrapeModeInternal { // Public mode needs no network policies
+		for _, exporter := range exporters {
+			policies = append(policies, NewPolicy(source, app.GUID, exporter.Port))
+		}
+		internalHosts, err = internalRouteHosts(routes, config.Domains, app)
+		if err != nil {
+			return policies, configs, 0, err
+		}
+		reachableHosts = internalHosts
 	}
-
-	policies = append(policies, NewPolicy(source, app.GUID, portNumber))
-	internalHost, err := InternalHost(session, internalDomainID, app)
-	if err != nil {
-		return policies, configs, err
+	var targetsPort int
+	if port := metadata.Annotations[AnnotationTargetsPort]; port != nil {
+		targetsPort, err = strconv.Atoi(*port)
+		if err != nil { // Scrape the exporter itself instead
+			invalid = append(invalid, fmt.Errorf("%s: %w", AnnotationTargetsPort, err))
+			targetsPort = 0
+		}
 	}
-	var targets []string
-	for count := 0; count < instanceCount; count++ {
-		target := fmt.Sprintf("%d.%s:%d", count, internalHost, portNumber)
-		targets = append(targets, target)
+	for i, exporter := range exporters {
+		name := JobName(config, app, metadata, exporter.JobSuffix)
+		hosts := internalHosts
+		targetStates := states
+		targetExporter := exporter
+		scheme := exporter.Scheme
+		if mode == ScrapeModePublic { // Instances are reached through the gorouter
+			hosts = PublicHosts(routes, config.Domains, app, exporter.Port)
+			for processType, host := range hosts {
+				reachableHosts[processType] = host
+			}
+			targetStates.index = true
+			targetExporter.Port = publicPort
+			scheme = "https"
+		}
+		targetProcesses := processes
+		targetLabels := TargetLabels(config, app, metadata.Labels)
+		if exporter.Sidecar != "" { // Only reachable on the instances the sidecar runs alongside
+			sidecar, _ := FindSidecar(sidecars, exporter.Sidecar)
+			targetProcesses = sidecar.Processes(processes)
+			targetLabels["cf_sidecar"] = sidecar.Name
+		}
+		groups := processTargetGroups(targetProcesses, hosts, targetStates, targetExporter, targetLabels)
+		if len(groups) == 0 { // No reachable process for this exporter
+			continue
+		}
+		scrapeConfig, settingsErr := newScrapeConfig(config, name, exporter, scheme, groups)
+		if err := applyCredential(config, &scrapeConfig, exporter.Credentials, app); err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+		if settingsErr != nil {
+			invalid = append(invalid, settingsErr)
+		}
+		instanceName := ""
+		if name := metadata.Annotations[AnnotationInstanceName]; name != nil {
+			instanceName = *name
+		}
+		if instanceName != "" {
+			targetRegex := "([^.]*).(.*)" // This match our own target format: ${1} = instanceIndex, ${2} = host:port
+			if regex := metadata.Annotations[AnnotationInstanceSourceRegex]; regex != nil {
+				targetRegex = *regex
+			}
+			scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &promconfig.RelabelConfig{
+				TargetLabel:  "instance",
+				SourceLabels: []string{"instance"},
+				Replacement:  instanceName,
+				Action:       "replace",
+				Regex:        targetRegex,
+			})
+		}
+		// Multiple host scraping, only for the first exporter and over the internal domain
+		if targetsPort != 0 && i == 0 && mode == ScrapeModeInternal {
+			internalHost := primaryHost(internalHosts)
+			if exporter.ProcessType != "" {
+				internalHost = internalHosts[exporter.ProcessType]
+			}
+			targetsPath := "/targets"
+			if p := metadata.Annotations[AnnotationTargetsPath]; p != nil {
+				targetsPath = *p
+			}
+			targetsURL := fmt.Sprintf("%s://%s:%d%s", exporter.Scheme, internalHost, targetsPort, targetsPath)
+			policies = append(policies, NewPolicy(source, app.GUID, targetsPort))
+			scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs,
+				&promconfig.RelabelConfig{
+					SourceLabels: []string{"__address__"},
+					TargetLabel:  "__param_target",
+				},
+				&promconfig.RelabelConfig{
+					SourceLabels: []string{"__param_target"},
+					TargetLabel:  "instance",
+				},
+				&promconfig.RelabelConfig{
+					TargetLabel: "__address__",
+					Replacement: fmt.Sprintf("%s:%d", internalHost, exporter.Port),
+				})
+			scrapeConfig.ServiceDiscoveryConfig = promconfig.ServiceDiscoveryConfig{
+				HTTPSDConfigs: []*promconfig.HTTPSDConfig{
+					{URL: targetsURL},
+				},
+			}
+		}
+		if err := appendRelabelConfigs(&scrapeConfig, exporter); err != nil {
+			invalid = append(invalid, fmt.Errorf("%s: %w", name, err))
+		}
+		if mode == ScrapeModePublic {
+			configs = append(configs, instanceScrapeConfigs(scrapeConfig, app.GUID)...)
+			continue
+		}
+		configs = append(configs, scrapeConfig)
+	}
+	if mode == ScrapeModePublic && len(configs) == probeConfigs {
+		return policies, configs, 0, fmt.Errorf("no public route found")
 	}
-	scrapeConfig := promconfig.ScrapeConfig{
-		JobName: jobName,
+	return policies, configs, states.excluded(processes, reachableHosts), invalidScrapeSettings(invalid...)
+}
+
+// newScrapeConfig returns the scrape config of an exporter with the operator settings
+// applied. Invalid exporter settings are skipped and returned as error.
+// Credentials are set by the caller, as these differ between apps and service instances.
+func newScrapeConfig(config Config, name string, exporter Exporter, scheme string, groups []*promconfig.Group) (ScrapeConfig, error) {
+	scrapeConfig := ScrapeConfig{ScrapeConfig: promconfig.ScrapeConfig{
+		JobName: name,
 		HTTPClientConfig: promconfig.HTTPClientConfig{
 			FollowRedirects: true,
 		},
 		HonorTimestamps: true,
 		Scheme:          scheme,
-		MetricsPath:     scrapePath,
+		MetricsPath:     exporter.Path,
 		ServiceDiscoveryConfig: promconfig.ServiceDiscoveryConfig{
-			StaticConfigs: []*promconfig.Group{
-				{
-					Targets: targets,
-					Labels: map[string]string{
-						"cf_app_name":   app.Name,
-						"cf_space_name": app.SpaceName,
-						"cf_org_name":   app.OrgName,
-					},
-				},
-			},
+			StaticConfigs: groups,
 		},
-	}
-	if scrapeInterval := metadata.Annotations[AnnotationExporterScrapInterval]; scrapeInterval != nil {
-		if err := scrapeConfig.ScrapeInterval.Set(*scrapeInterval); err != nil {
-			return policies, configs, err
+	}}
+	var invalid []error
+	if exporter.Interval != "" {
+		if err := scrapeConfig.ScrapeInterval.Set(exporter.Interval); err != nil {
+			invalid = append(invalid, fmt.Errorf("scrape_interval: %w", err))
 		}
 	}
-	if MetricsEndpointBasicAuthEnabled() {
-		scrapeConfig.HTTPClientConfig = promconfig.HTTPClientConfig{
-			BasicAuth: &promconfig.BasicAuth{
-				Username: viper.GetString("basic_auth_username"),
-				Password: viper.GetString("basic_auth_password"),
-			},
-			FollowRedirects: true,
-		}
+	if err := exporter.ScrapeTuning.Apply(&scrapeConfig, config.ScrapeLimits, config.globalScrapeInterval); err != nil {
+		invalid = append(invalid, err)
 	}
-	instanceName := ""
-	if name := metadata.Annotations[AnnotationInstanceName]; name != nil {
-		instanceName = *name
+	scrapeConfig.HTTPClientConfig.TLSConfig = exporter.TLSConfig.ToProm(config.TLSConfig)
+	if len(invalid) > 0 {
+		return scrapeConfig, fmt.Errorf("%s: %w", name, errors.Join(invalid...))
 	}
-	if instanceName != "" {
-		targetRegex := "([^.]*).(.*)" // This match our own target format: ${1} = instanceIndex, ${2} = host:port
-		if regex := metadata.Annotations[AnnotationInstanceSourceRegex]; regex != nil {
-			targetRegex = *regex
-		}
-		scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &promconfig.RelabelConfig{
-			TargetLabel:  "instance",
-			SourceLabels: []string{"instance"},
-			Replacement:  instanceName,
-			Action:       "replace",
-			Regex:        targetRegex,
-		})
+	return scrapeConfig, nil
+}
+
+// applyCredential sets the named credential, or the default credential of the space or org, on
+// the scrape config of an app. Variant's own basic auth credentials are never used, as app
+// owners could capture these by pointing their exporter at a server of their own.
+func applyCredential(config Config, scrapeConfig *ScrapeConfig, name string, app App) error {
+	credential, err := config.Credentials.Resolve(name, app)
+	if err != nil {
+		return fmt.Errorf("%s: %w", scrapeConfig.JobName, err)
 	}
-	// Multiple host scraping
-	if port := metadata.Annotations[AnnotationTargetsPort]; port != nil {
-		targetsPort, err := strconv.Atoi(*port)
-		if err != nil {
-			return policies, configs, err
-		}
-		targetsPath := "/targets"
-		if p := metadata.Annotations[AnnotationTargetsPath]; p != nil {
-			targetsPath = *p
-		}
-		targetsURL := fmt.Sprintf("%s://%s:%d%s", scheme, internalHost, targetsPort, targetsPath)
-		policies = append(policies, NewPolicy(source, app.GUID, targetsPort))
-		scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs,
-			&promconfig.RelabelConfig{
-				SourceLabels: []string{"__address__"},
-				TargetLabel:  "__param_target",
-			},
-			&promconfig.RelabelConfig{
-				SourceLabels: []string{"__param_target"},
-				TargetLabel:  "instance",
-			},
-			&promconfig.RelabelConfig{
-				TargetLabel: "__address__",
-				Replacement: fmt.Sprintf("%s:%d", internalHost, portNumber),
-			})
-		scrapeConfig.ServiceDiscoveryConfig = promconfig.ServiceDiscoveryConfig{
-			HTTPSDConfigs: []*promconfig.HTTPSDConfig{
-				{URL: targetsURL},
-			},
-		}
+	if credential != nil {
+		credential.Apply(&scrapeConfig.HTTPClientConfig)
 	}
-	// Extra relabel config
-	if relabelConfigs := metadata.Annotations[AnnotationRelabelConfigs]; relabelConfigs != nil {
-		var relabelConfig []*RelabelConfig
-		err := json.Unmarshal([]byte(*relabelConfigs), &relabelConfig)
-		if err != nil {
-			return policies, configs, err
-		}
-		for _, r := range relabelConfig {
-			scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs, r.ToProm())
-		}
+	return nil
+}
+
+// defaultHTTPClientConfig returns the client settings of app scrape configs without
+// exporter specific TLS or credential settings
+func defaultHTTPClientConfig(config Config) promconfig.HTTPClientConfig {
+	return promconfig.HTTPClientConfig{
+		FollowRedirects: true,
+		TLSConfig:       config.TLSConfig,
 	}
-	configs = append(configs, scrapeConfig)
-	return policies, configs, nil
+}
+
+// appendRelabelConfigs adds the valid relabel configs of the exporter to the scrape config
+func appendRelabelConfigs(scrapeConfig *ScrapeConfig, exporter Exporter) error {
+	relabels, relabelErr := relabelConfigs("relabel_configs", exporter.RelabelConfigs)
+	scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs, relabels...)
+	metricRelabels, metricRelabelErr := relabelConfigs("metric_relabel_configs", exporter.MetricRelabelConfigs)
+	scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, metricRelabels...)
+	return errors.Join(relabelErr, metricRelabelErr)
 }
 
 func GetMD5Hash(cfg string) string {
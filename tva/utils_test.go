@@ -1,12 +1,18 @@
 package tva_test
 
 import (
+	"errors"
+	"io"
+	"net/http"
 	"testing"
 	"variant/tva"
 
 	"code.cloudfoundry.org/cli/resources"
 	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/percona/promconfig"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 )
 
 func TestParseRules(t *testing.T) {
@@ -38,6 +44,16 @@ func TestParseRules(t *testing.T) {
 	assert.Equal(t, "KongWaitingConnections", parsedRules[0].Alert)
 	assert.Equal(t, "1m", parsedRules[1].For)
 	assert.Equal(t, "TransactionsHSDPPG", parsedRules[1].Alert)
+
+	// Indexed rules which do not parse are reported, the others are kept
+	broken := `{"alert": "Broken",`
+	metadata.Annotations["prometheus.rules.2.json"] = &broken
+	parsedRules, err = tva.ParseRules(metadata)
+	assert.True(t, errors.Is(err, tva.ErrInvalidScrapeSettings))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "prometheus.rules.2.json")
+	}
+	assert.Len(t, parsedRules, 2)
 }
 
 func TestGeneratePoliciesAndScrapeConfigs(t *testing.T) {
@@ -61,9 +77,222 @@ func TestGeneratePoliciesAndScrapeConfigs(t *testing.T) {
 			State: "STARTED",
 		},
 	}
-	policies, configs, err := tva.GeneratePoliciesAndScrapeConfigs(session, internalDomainID, thanosID, app)
+	// Variant's own credentials never go to app targets either
+	viper.Set("basic_auth_username", "variant")
+	viper.Set("basic_auth_password", "secret")
+	defer func() {
+		viper.Set("basic_auth_username", "")
+		viper.Set("basic_auth_password", "")
+	}()
+
+	policies, configs, excluded, err := tva.GeneratePoliciesAndScrapeConfigs(session, tva.Config{
+		Domains:  map[string]string{internalDomainID: "apps.internal"},
+		ThanosID: thanosID,
+	}, app)
 	assert.Nil(t, err)
 	assert.Len(t, policies, 1)
-	assert.Len(t, configs, 1)
+	if assert.Len(t, configs, 1) {
+		assert.Nil(t, configs[0].HTTPClientConfig.BasicAuth)
+	}
+	assert.Equal(t, 0, excluded)
+
+	// An exporter referencing a credential its space may not use is skipped as invalid
+	ceresCredential = "team-a"
+	defer func() { ceresCredential = "" }()
+	_, configs, _, err = tva.GeneratePoliciesAndScrapeConfigs(session, tva.Config{
+		Domains:  map[string]string{internalDomainID: "apps.internal"},
+		ThanosID: thanosID,
+		Credentials: &tva.Credentials{
+			Credentials: map[string]tva.Credential{
+				"team-a": {BearerTokenFile: "/secrets/team-a", Spaces: []string{"other-space"}},
+			},
+		},
+	}, app)
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	assert.Len(t, configs, 0)
+}
+
+func TestParseExporters(t *testing.T) {
+	port := "8080"
+	metricRelabelConfigs := `[{"source_labels": ["__name__"], "regex": "go_.*", "action": "drop"}]`
+	exportersJSON := `[{"port": 9100, "job_suffix": "jvm", "interval": "1m"}, {"port": 9901, "path": "/stats/prometheus", "relabel_configs": [{"source_labels": ["__name__"], "regex": "envoy_.*", "action": "keep"}]}, {"port": 9102, "sidecar": "statsd-exporter"}]`
+
+	exporters, err := tva.ParseExporters(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationExporterPort:         &port,
+			tva.AnnotationExportersJSON:        &exportersJSON,
+			tva.AnnotationMetricRelabelConfigs: &metricRelabelConfigs,
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Len(t, exporters, 4) {
+		return
+	}
+	assert.Equal(t, 8080, exporters[0].Port)
+	if assert.Len(t, exporters[0].MetricRelabelConfigs, 1) {
+		assert.Equal(t, "drop", exporters[0].MetricRelabelConfigs[0].Action)
+	}
+	assert.Len(t, exporters[0].RelabelConfigs, 0)
+	assert.Equal(t, "", exporters[0].JobSuffix)
+	assert.Equal(t, "jvm", exporters[1].JobSuffix)
+	assert.Equal(t, "/metrics", exporters[1].Path)
+	assert.Equal(t, "1m", exporters[1].Interval)
+	assert.Equal(t, "9901", exporters[2].JobSuffix)
+	assert.Equal(t, "/stats/prometheus", exporters[2].Path)
+	assert.Len(t, exporters[2].RelabelConfigs, 1)
+	assert.Equal(t, "statsd-exporter", exporters[3].Sidecar)
+	assert.Equal(t, "statsd-exporter", exporters[3].JobSuffix)
+
+	// Only the JSON annotation
+	exporters, err = tva.ParseExporters(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationExportersJSON: &exportersJSON,
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Len(t, exporters, 3) {
+		return
+	}
+	assert.Equal(t, 9100, exporters[0].Port)
+	assert.Equal(t, "9901", exporters[1].JobSuffix)
+
+	missingPort := `[{"path": "/metrics"}, {"port": 9100}]`
+	exporters, err = tva.ParseExporters(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationExportersJSON: &missingPort,
+		},
+	})
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	if assert.Len(t, exporters, 1) {
+		assert.Equal(t, 9100, exporters[0].Port)
+	}
+
+	// Invalid settings are skipped, the exporter remains
+	sampleLimit := "abc"
+	labelLimit := "30"
+	honorLabels := "yes"
+	relabelConfigs := `{"action": "drop"`
+	exporters, err = tva.ParseExporters(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationExporterPort:        &port,
+			tva.AnnotationExporterSampleLimit: &sampleLimit,
+			tva.AnnotationExporterLabelLimit:  &labelLimit,
+			tva.AnnotationExporterHonorLabels: &honorLabels,
+			tva.AnnotationRelabelConfigs:      &relabelConfigs,
+		},
+	})
+	assert.ErrorIs(t, err, tva.ErrInvalidScrapeSettings)
+	if !assert.Len(t, exporters, 1) {
+		return
+	}
+	assert.Equal(t, 8080, exporters[0].Port)
+	assert.Nil(t, exporters[0].SampleLimit)
+	assert.Nil(t, exporters[0].HonorLabels)
+	assert.Len(t, exporters[0].RelabelConfigs, 0)
+	if assert.NotNil(t, exporters[0].LabelLimit) {
+		assert.Equal(t, uint(30), *exporters[0].LabelLimit)
+	}
+}
 
+// testMetrics records the gauges the tests check on
+type testMetrics struct {
+	invalidApps float64
+}
+
+func (m *testMetrics) SetScrapeInterval(float64)                  {}
+func (m *testMetrics) SetManagedNetworkPolicies(float64)          {}
+func (m *testMetrics) SetDetectedScrapeConfigs(float64)           {}
+func (m *testMetrics) SetExcludedInstances(float64)               {}
+func (m *testMetrics) SetInvalidApps(v float64)                   { m.invalidApps = v }
+func (m *testMetrics) IncTotalIncursions()                        {}
+func (m *testMetrics) IncErrorIncursions()                        {}
+func (m *testMetrics) IncConfigLoads()                            {}
+func (m *testMetrics) IncConfigCacheHits()                        {}
+func (m *testMetrics) IncOutOfBoundChanges()                      {}
+func (m *testMetrics) IncWriteErrors()                            {}
+func (m *testMetrics) IncReloadFailures()                         {}
+func (m *testMetrics) SetQuarantinedRules([]tva.QuarantinedRules) {}
+
+func TestReconcileInvalidScrapeSettings(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	muxCF.HandleFunc("/v3/apps/9e22fe38-38ce-4af6-b529-44d2853d072f/environment_variables", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{"var": {"VARIANT_EXPORTER_SAMPLE_LIMIT": "abc"}}`)
+	})
+	metrics := &testMetrics{}
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: prometheusConfig,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        serverThanos.URL,
+		EnvConfig:        true,
+	}, tva.WithTenants("default"), tva.WithReload(false), tva.WithMetrics(metrics))
+	if !assert.Nil(t, err) {
+		return
+	}
+	output, err := timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	var cfg tva.PrometheusConfig
+	if !assert.Nil(t, yaml.Unmarshal([]byte(output), &cfg)) {
+		return
+	}
+	if !assert.Len(t, cfg.ScrapeConfigs, 3) {
+		return
+	}
+	assert.Equal(t, "ceres-9e22fe38", cfg.ScrapeConfigs[2].JobName)
+	assert.Equal(t, float64(1), metrics.invalidApps)
+}
+
+func TestParseTLSConfig(t *testing.T) {
+	tlsConfig, err := tva.ParseTLSConfig(tva.Metadata{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, tlsConfig)
+	defaults := promconfig.TLSConfig{
+		CAFile:             "/etc/cf-instance-identity/ca.crt",
+		InsecureSkipVerify: true,
+	}
+	assert.Equal(t, defaults, tlsConfig.ToProm(defaults))
+
+	serverName := "exporter.apps.internal"
+	insecure := "false"
+	tlsConfig, err = tva.ParseTLSConfig(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationTLSServerName:         &serverName,
+			tva.AnnotationTLSInsecureSkipVerify: &insecure,
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.NotNil(t, tlsConfig) {
+		return
+	}
+	assert.Equal(t, promconfig.TLSConfig{
+		CAFile:             "/etc/cf-instance-identity/ca.crt",
+		ServerName:         "exporter.apps.internal",
+		InsecureSkipVerify: false,
+	}, tlsConfig.ToProm(defaults))
+
+	bogus := "maybe"
+	_, err = tva.ParseTLSConfig(tva.Metadata{
+		Annotations: map[string]*string{
+			tva.AnnotationTLSInsecureSkipVerify: &bogus,
+		},
+	})
+	assert.NotNil(t, err)
 }
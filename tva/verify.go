@@ -0,0 +1,225 @@
+package tva
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	reloadPollInterval = time.Second
+	rejectedBundle     = "rejected"
+)
+
+// ErrReloadRejected is returned when Prometheus did not accept a new config
+var ErrReloadRejected = errors.New("reload rejected")
+
+// ConfigApplied reports whether the running config Prometheus renders holds the expected
+// config. Prometheus fills in defaults, redacts secrets and resolves file paths against
+// the config folder when rendering, so both configs are normalized through the same
+// struct and only the settings written in the expected config are compared by hash.
+func ConfigApplied(expected, running string) (bool, error) {
+	want, shape, err := normalizeConfig(expected)
+	if err != nil {
+		return false, err
+	}
+	got, _, err := normalizeConfig(running)
+	if err != nil {
+		return false, err
+	}
+	want = writtenSettings(want, shape)
+	got = renderedLike(writtenSettings(got, shape), want)
+	wantYAML, err := yaml.Marshal(want)
+	if err != nil {
+		return false, err
+	}
+	gotYAML, err := yaml.Marshal(got)
+	if err != nil {
+		return false, err
+	}
+	return GetMD5Hash(string(wantYAML)) == GetMD5Hash(string(gotYAML)), nil
+}
+
+// normalizeConfig returns the config as loaded into PrometheusConfig, and as written
+func normalizeConfig(config string) (interface{}, interface{}, error) {
+	var cfg PrometheusConfig
+	if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+		return nil, nil, fmt.Errorf("load config: %w", err)
+	}
+	normalized, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load config: %w", err)
+	}
+	var value, written interface{}
+	if err := yaml.Unmarshal(normalized, &value); err != nil {
+		return nil, nil, fmt.Errorf("load config: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(config), &written); err != nil {
+		return nil, nil, fmt.Errorf("load config: %w", err)
+	}
+	return value, written, nil
+}
+
+// writtenSettings keeps the settings of the value which are also set in the shape
+func writtenSettings(value, shape interface{}) interface{} {
+	switch s := shape.(type) {
+	case map[interface{}]interface{}:
+		v, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return value
+		}
+		kept := make(map[interface{}]interface{})
+		for k := range s {
+			if x, ok := v[k]; ok {
+				kept[k] = writtenSettings(x, s[k])
+			}
+		}
+		return kept
+	case []interface{}:
+		v, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		kept := make([]interface{}, len(v))
+		for i := range v {
+			kept[i] = v[i]
+			if i < len(s) {
+				kept[i] = writtenSettings(v[i], s[i])
+			}
+		}
+		return kept
+	}
+	return value
+}
+
+// renderedLike replaces the redacted secrets and resolved file paths of the running
+// config with the expected values
+func renderedLike(running, expected interface{}) interface{} {
+	switch r := running.(type) {
+	case map[interface{}]interface{}:
+		e, _ := expected.(map[interface{}]interface{})
+		for k := range r {
+			r[k] = renderedLike(r[k], e[k])
+		}
+	case []interface{}:
+		e, _ := expected.([]interface{})
+		for i := range r {
+			if i < len(e) {
+				r[i] = renderedLike(r[i], e[i])
+			}
+		}
+	case string:
+		e, ok := expected.(string)
+		if !ok || e == "" {
+			return running
+		}
+		if r == "<secret>" || (path.IsAbs(r) && !path.IsAbs(e) && strings.HasSuffix(r, "/"+path.Clean(e))) {
+			return e
+		}
+	}
+	return running
+}
+
+// reloadAndVerify triggers a reload, unless Prometheus picks up changes itself through
+// inotify, and verifies Prometheus runs the new config written at the given time when enabled
+func (t *Timeline) reloadAndVerify(newConfig string, written time.Time) error {
+	if t.reload {
+		if err := t.reloadPrometheus(); err != nil {
+			return err
+		}
+	}
+	if !t.config.VerifyReload {
+		return nil
+	}
+	return t.verifyReload(newConfig, written)
+}
+
+// verifyReload checks the last reload was successful and Prometheus runs the expected
+// config. With inotify the reload happens asynchronously, so this polls until the reload
+// timeout. As the config does not change when only targets do, the running config
+// only counts once Prometheus loaded it after it was written. A config which cannot be
+// verified, e.g. because the API is unreachable, is not reported as rejected.
+func (t *Timeline) verifyReload(expected string, written time.Time) error {
+	if _, _, err := normalizeConfig(expected); err != nil {
+		return err
+	}
+	written = written.Truncate(time.Second) // Prometheus reports the reload time in seconds
+	deadline := time.Now().Add(t.reloadTimeout())
+	for {
+		timeout := time.Until(deadline)
+		if timeout < reloadPollInterval {
+			timeout = reloadPollInterval
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		info, infoErr := t.v1API.Runtimeinfo(ctx)
+		running, configErr := t.v1API.Config(ctx)
+		cancel()
+		if infoErr == nil && !info.ReloadConfigSuccess {
+			return fmt.Errorf("%w: last reload was not successful", ErrReloadRejected)
+		}
+		reloaded := infoErr == nil && !info.LastConfigTime.Before(written)
+		if reloaded && configErr == nil {
+			if applied, err := ConfigApplied(expected, running.YAML); err == nil && applied {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if infoErr != nil || configErr != nil {
+				return fmt.Errorf("verify reload: %w", errors.Join(infoErr, configErr))
+			}
+			if !reloaded {
+				return fmt.Errorf("%w: config was not reloaded", ErrReloadRejected)
+			}
+			return fmt.Errorf("%w: running config does not match", ErrReloadRejected)
+		}
+		time.Sleep(reloadPollInterval)
+	}
+}
+
+// reloadTimeout returns how long to wait for Prometheus to run a new config. As the
+// timeline is locked meanwhile, it stays well below the reconcile frequency.
+func (t *Timeline) reloadTimeout() time.Duration {
+	timeout := t.config.ReloadTimeout
+	if limit := t.frequency * time.Second / 3; limit > 0 && timeout > limit {
+		timeout = limit
+	}
+	return timeout
+}
+
+// rollback restores the backup taken before the rejected config was written. The rejected
+// config and its rule files are kept in the rejected folder of the backups for debugging.
+func (t *Timeline) rollback(backup Backup, rejected []byte, hash string) error {
+	t.rejected = hash
+	if t.metrics != nil {
+		t.metrics.IncReloadFailures()
+	}
+	bundle := filepath.Join(t.backupDir(), rejectedBundle)
+	_ = os.RemoveAll(bundle)
+	created := time.Now()
+	_, err := t.writeBundle(bundle, Backup{
+		ID:        rejectedBundle + "-" + strconv.FormatInt(created.UnixNano(), 10), // Never listed as backup
+		Created:   created.UTC(),
+		Hash:      GetMD5Hash(string(rejected)),
+		Reconcile: t.written,
+		Config:    path.Base(t.config.PrometheusConfig),
+	}, rejected)
+	if err != nil {
+		fmt.Printf("error keeping rejected config: %v\n", err)
+	}
+	if err := t.restoreBundle(backup); err != nil {
+		return err
+	}
+	fmt.Printf("config rejected, restored backup %s\n", backup.ID)
+	if !t.reload {
+		return nil
+	}
+	return t.reloadPrometheus()
+}
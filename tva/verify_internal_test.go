@@ -0,0 +1,20 @@
+package tva
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadTimeout(t *testing.T) {
+	timeline := &Timeline{config: Config{ReloadTimeout: 30 * time.Second}}
+	assert.Equal(t, 30*time.Second, timeline.reloadTimeout())
+
+	// The timeline stays locked while verifying, so the timeout stays below the frequency
+	timeline.frequency = 15
+	assert.Equal(t, 5*time.Second, timeline.reloadTimeout())
+
+	timeline.config.ReloadTimeout = 2 * time.Second
+	assert.Equal(t, 2*time.Second, timeline.reloadTimeout())
+}
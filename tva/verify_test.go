@@ -0,0 +1,201 @@
+package tva_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"variant/tva"
+
+	clients "github.com/cloudfoundry-community/go-cf-clients-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigApplied(t *testing.T) {
+	expected := `global:
+  scrape_interval: 60s
+rule_files:
+- rules/ceres.yml
+scrape_configs:
+- job_name: b
+  basic_auth:
+    username: scraper
+    password: secret
+- job_name: a
+  static_configs:
+  - targets: [a:8080]
+`
+	// Prometheus fills in defaults, redacts secrets and resolves file paths when rendering its running config
+	applied, err := tva.ConfigApplied(expected, `global:
+  scrape_interval: 1m
+  evaluation_interval: 1m
+rule_files:
+- /etc/prometheus/rules/ceres.yml
+scrape_configs:
+- job_name: b
+  honor_timestamps: true
+  metrics_path: /metrics
+  basic_auth:
+    username: scraper
+    password: <secret>
+  follow_redirects: true
+- job_name: a
+  honor_timestamps: true
+  static_configs:
+  - targets: [a:8080]
+`)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, applied)
+
+	// Any written setting counts, not just the job names
+	applied, err = tva.ConfigApplied(expected, `global:
+  scrape_interval: 1m
+rule_files:
+- /etc/prometheus/rules/ceres.yml
+scrape_configs:
+- job_name: b
+  basic_auth:
+    username: scraper
+    password: <secret>
+- job_name: a
+  static_configs:
+  - targets: [a:9090]
+`)
+	assert.Nil(t, err)
+	assert.False(t, applied)
+
+	_, err = tva.ConfigApplied("scrape_configs: {", expected)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyReload(t *testing.T) {
+	teardown := setup(t)
+	defer teardown()
+
+	original, err := os.ReadFile(prometheusConfig)
+	if !assert.Nil(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "prometheus.yml")
+	if !assert.Nil(t, os.WriteFile(configFile, original, 0644)) {
+		return
+	}
+	reloadSuccess := false
+	var lastReload time.Time // Zero means Prometheus reloads right away
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/status/runtimeinfo", func(w http.ResponseWriter, r *http.Request) {
+		reloaded := lastReload
+		if reloaded.IsZero() {
+			reloaded = time.Now()
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"reloadConfigSuccess": reloadSuccess,
+				"lastConfigTime":      reloaded.Format(time.RFC3339),
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/status/config", func(w http.ResponseWriter, r *http.Request) {
+		running, _ := os.ReadFile(configFile)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"yaml": string(running)},
+		})
+	})
+	prometheus := httptest.NewServer(mux)
+	defer prometheus.Close()
+
+	timeline, err := tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        prometheus.URL,
+		VerifyReload:     true,
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Rejected configs are rolled back and kept for debugging
+	output, err := timeline.Reconcile()
+	assert.True(t, errors.Is(err, tva.ErrReloadRejected))
+	restored, _ := os.ReadFile(configFile)
+	assert.Equal(t, string(original), string(restored))
+	rejected, err := os.ReadFile(filepath.Join(dir, "backups", "rejected", "prometheus.yml"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, output, string(rejected))
+	}
+	backups, _ := timeline.Backups()
+	assert.Len(t, backups, 1)
+
+	// The same config is not tried again
+	_, err = timeline.Reconcile()
+	assert.True(t, errors.Is(err, tva.ErrReloadRejected))
+	backups, _ = timeline.Backups()
+	assert.Len(t, backups, 1)
+
+	// A config Prometheus did not reload since it was written is not verified by the
+	// running config alone
+	reloadSuccess = true
+	lastReload = time.Now().Add(-time.Hour)
+	timeline, err = tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        prometheus.URL,
+		VerifyReload:     true,
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = timeline.Reconcile()
+	if assert.True(t, errors.Is(err, tva.ErrReloadRejected)) {
+		assert.Contains(t, err.Error(), "not reloaded")
+	}
+	restored, _ = os.ReadFile(configFile)
+	assert.Equal(t, string(original), string(restored))
+
+	// Accepted configs stay
+	lastReload = time.Time{}
+	written := output
+	timeline, err = tva.NewTimeline(tva.Config{
+		Config: clients.Config{
+			Endpoint: serverCF.URL,
+			User:     "ron",
+			Password: "swanson",
+		},
+		PrometheusConfig: configFile,
+		InternalDomainID: internalDomainID,
+		ThanosID:         thanosID,
+		ThanosURL:        prometheus.URL,
+		VerifyReload:     true,
+	}, tva.WithTenants("default"), tva.WithReload(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+	output, err = timeline.Reconcile()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, written, output)
+	current, _ := os.ReadFile(configFile)
+	assert.Equal(t, output, string(current))
+}